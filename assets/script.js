@@ -10,11 +10,27 @@ document.addEventListener("DOMContentLoaded", async () => {
     document.querySelector("#nav-btn").checked = false;
     updateCodeEditCount(document.querySelector("#code-edit").value.length);
 
+    if (key && document.querySelector("#private-message") && hasPermission(getToken(key), "read")) {
+        // the server only renders private documents for requests with a token, so we fetch it ourselves
+        const state = await fetchDocument(key, version);
+        if (state) {
+            document.querySelector("#private-message").remove();
+            await fetchVersions(key, state.newState.version);
+            updateCode(state.newState);
+            updatePage(state.newState);
+            window.history.replaceState(state.newState, "", state.url);
+            return;
+        }
+    }
+
     let content = "", language = "";
     if (key) {
         content = document.querySelector("#code-edit").value;
         language = document.querySelector("#language").value;
     }
+    if (key && content && document.querySelector("#encrypt").checked) {
+        content = await decryptDocument(content);
+    }
     const {newState, url} = createState(key, version, key ? "view" : "edit", content, language);
     updateCode(newState);
     updatePage(newState);
@@ -95,41 +111,71 @@ document.querySelector("#save").addEventListener("click", async () => {
     const {key, mode, content, language} = getState()
     if (mode !== "edit") return;
     const token = getToken(key);
+    const expires = document.querySelector("#expires").value;
+    const encrypt = document.querySelector("#encrypt").checked;
+    const isPrivate = document.querySelector("#private").checked;
+    const isPublic = document.querySelector("#public").checked;
     const saveButton = document.querySelector("#save");
     saveButton.classList.add("loading");
 
+    let body = content, encryptionKey = "";
+    if (encrypt) {
+        // existing documents have to keep their key, new documents get a new one
+        encryptionKey = (key && token && getEncryptionKey()) || await generateEncryptionKey();
+        body = await encryptContent(encryptionKey, content);
+    }
+    const query = `${encrypt ? "encrypted=true" : "formatter=html"}${language ? `&language=${language || "auto"}` : ""}${expires ? `&expires=${expires}` : ""}&private=${isPrivate}&public=${isPublic}`;
+
     let response;
     if (key && token) {
-        response = await fetch(`/documents/${key}?formatter=html${language ? `&language=${language || "auto"}` : ""}`, {
+        response = await fetch(`/documents/${key}?${query}`, {
             method: "PATCH",
-            body: content,
-            headers: {
-                Authorization: `Bearer ${token}`,
-            }
+            body: body,
+            headers: getIfMatchHeaders(key, token)
         });
     } else {
-        response = await fetch(`/documents?formatter=html${language ? `&language=${language || "auto"}` : ""}`, {
+        response = await fetch(`/documents?${query}`, {
             method: "POST",
-            body: content,
+            body: body,
         });
     }
     saveButton.classList.remove("loading");
 
-    const body = await response.json();
+    const responseBody = await response.json();
+    if (response.status === 412) {
+        // keep the edited content, so it can be copied before loading the changes of the other editor
+        showErrorPopup(`This document was changed to version ${responseBody.version} while you were editing it. Copy your changes and reload the page to see the new version before saving again.`);
+        return;
+    }
     if (!response.ok) {
-        showErrorPopup(body.message || response.statusText);
+        showErrorPopup(responseBody.message || response.statusText);
         console.error("error saving document:", response);
         return;
     }
+    await showSavedDocument(responseBody, content, encryptionKey);
+});
 
-    const {newState, url} = createState(body.key, "", "view", content, body.language);
+async function showSavedDocument(body, content, encryptionKey) {
+    let {newState, url} = createState(body.key, "", "view", content, body.language);
+    if (encryptionKey) {
+        url = `${url.split("#")[0]}#key=${encryptionKey}`;
+    }
     if (body.token) {
         setToken(body.key, body.token);
     }
-    document.querySelector("#code-view").innerHTML = body.formatted;
-    document.querySelector("#code-style").innerHTML = body.css;
-    document.querySelector("#code-edit").value = body.data;
+    updateRenderError(body.render_error);
+    if (body.encrypted) {
+        document.querySelector("#code-view").textContent = content;
+        document.querySelector("#code-edit").value = content;
+    } else {
+        document.querySelector("#code-view").innerHTML = body.formatted;
+        document.querySelector("#code-style").innerHTML = body.css;
+        document.querySelector("#code-edit").value = body.data;
+    }
     document.querySelector("#language").value = body.language;
+    document.querySelector("#private").checked = !!body.private;
+    document.querySelector("#public").checked = !!body.public;
+    updateExpiresAt(body.expires_at);
 
     const optionElement = document.createElement("option")
     optionElement.title = `${body.version_time}`;
@@ -143,7 +189,7 @@ document.querySelector("#save").addEventListener("click", async () => {
     updateCode(newState);
     updatePage(newState);
     window.history.pushState(newState, "", url);
-});
+}
 
 document.querySelector("#delete").addEventListener("click", async () => {
     if (document.querySelector("#delete").disabled) return;
@@ -159,12 +205,15 @@ document.querySelector("#delete").addEventListener("click", async () => {
     deleteButton.classList.add("loading");
     let response = await fetch(`/documents/${key}`, {
         method: "DELETE",
-        headers: {
-            Authorization: `Bearer ${token}`
-        }
+        headers: getIfMatchHeaders(key, token)
     });
     deleteButton.classList.remove("loading");
 
+    if (response.status === 412) {
+        const body = await response.json();
+        showErrorPopup(`This document was changed to version ${body.version} since you loaded it. Reload the page to see the new version before deleting it.`);
+        return;
+    }
     if (!response.ok) {
         const body = await response.json();
         showErrorPopup(body.message || response.statusText)
@@ -189,8 +238,14 @@ document.querySelector("#copy").addEventListener("click", async () => {
 document.querySelector("#raw").addEventListener("click", () => {
     if (document.querySelector("#raw").disabled) return;
 
-    const {key, version} = getState();
+    const {key, version, content} = getState();
     if (!key) return;
+    if (getPassword(key) || document.querySelector("#encrypt").checked || document.querySelector("#private").checked) {
+        // the raw endpoint can't be opened with the password header or token or decrypt the document, so we open the content we already have
+        const blob = new Blob([content], {type: "text/plain; charset=UTF-8"});
+        window.open(URL.createObjectURL(blob), "_blank").focus();
+        return;
+    }
     window.open(`/raw/${key}${version ? `/versions/${version}` : ""}`, "_blank").focus();
 })
 
@@ -204,9 +259,11 @@ document.querySelector("#share").addEventListener("click", async () => {
         return;
     }
 
+    document.querySelector("#share-permissions-read").checked = false;
     document.querySelector("#share-permissions-write").checked = false;
     document.querySelector("#share-permissions-delete").checked = false;
     document.querySelector("#share-permissions-share").checked = false;
+    document.querySelector("#share-ttl").value = "";
 
     document.querySelector("#share-dialog").showModal();
 });
@@ -217,6 +274,9 @@ document.querySelector("#share-dialog-close").addEventListener("click", () => {
 
 document.querySelector("#share-copy").addEventListener("click", async () => {
     const permissions = [];
+    if (document.querySelector("#share-permissions-read").checked) {
+        permissions.push("read");
+    }
     if (document.querySelector("#share-permissions-write").checked) {
         permissions.push("write");
     }
@@ -238,7 +298,7 @@ document.querySelector("#share-copy").addEventListener("click", async () => {
 
     const response = await fetch(`/documents/${key}/share`, {
         method: "POST",
-        body: JSON.stringify({permissions: permissions}),
+        body: JSON.stringify({permissions: permissions, ttl: document.querySelector("#share-ttl").value}),
         headers: {
             "Content-Type": "application/json",
             Authorization: `Bearer ${token}`
@@ -287,9 +347,89 @@ document.querySelector("#version").addEventListener("change", async (event) => {
     window.history.pushState(newState, "", url);
 })
 
+document.querySelector("#password-form")?.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    const {key, version} = getState();
+    setPassword(key, document.querySelector("#password").value);
+
+    const state = await fetchDocument(key, version);
+    if (!state) {
+        deletePassword(key);
+        return;
+    }
+    document.querySelector("#password-form").remove();
+    await fetchVersions(key, state.newState.version);
+
+    updateCode(state.newState);
+    updatePage(state.newState);
+    window.history.replaceState(state.newState, "", state.url);
+});
+
+// documents can't be private and public at the same time
+document.querySelector("#private").addEventListener("change", (event) => {
+    if (event.target.checked) document.querySelector("#public").checked = false;
+});
+
+document.querySelector("#public").addEventListener("change", (event) => {
+    if (event.target.checked) document.querySelector("#private").checked = false;
+});
+
+document.querySelector("#search").addEventListener("click", () => {
+    if (document.querySelector("#search").disabled) return;
+
+    document.querySelector("#search-dialog").showModal();
+    document.querySelector("#search-query").focus();
+});
+
+document.querySelector("#search-dialog-close").addEventListener("click", () => {
+    document.querySelector("#search-dialog").close();
+});
+
+document.querySelector("#search-form").addEventListener("submit", async (event) => {
+    event.preventDefault();
+    const documents = JSON.parse(localStorage.getItem("documents") || "{}");
+
+    const response = await fetch("/documents/search", {
+        method: "POST",
+        body: JSON.stringify({query: document.querySelector("#search-query").value, tokens: Object.values(documents)}),
+        headers: {
+            "Content-Type": "application/json"
+        }
+    });
+
+    const body = await response.json();
+    if (!response.ok) {
+        showErrorPopup(body.message || response.statusText);
+        console.error("error searching documents:", response);
+        return;
+    }
+
+    const resultsElement = document.querySelector("#search-results");
+    resultsElement.innerHTML = "";
+    if (body.length === 0) {
+        const itemElement = document.createElement("li");
+        itemElement.innerText = "No documents found";
+        resultsElement.appendChild(itemElement);
+        return;
+    }
+    for (const result of body) {
+        const linkElement = document.createElement("a");
+        linkElement.href = `/${result.key}`;
+        linkElement.innerText = `${result.key} (${result.language})`;
+        const snippetElement = document.createElement("p");
+        snippetElement.textContent = result.snippet;
+
+        const itemElement = document.createElement("li");
+        itemElement.appendChild(linkElement);
+        itemElement.appendChild(snippetElement);
+        resultsElement.appendChild(itemElement);
+    }
+});
+
 async function fetchDocument(key, version, language) {
     const response = await fetch(`/documents/${key}${version ? `/versions/${version}` : ""}?formatter=html${language ? `&language=${language}` : ""}`, {
-        method: "GET"
+        method: "GET",
+        headers: getHeaders(key)
     });
 
     const body = await response.json();
@@ -298,6 +438,16 @@ async function fetchDocument(key, version, language) {
         console.error("error fetching document version:", response);
         return;
     }
+    document.querySelector("#private").checked = !!body.private;
+    document.querySelector("#public").checked = !!body.public;
+    updateRenderError(body.render_error);
+
+    if (body.encrypted) {
+        const content = await decryptDocument(body.data);
+        document.querySelector("#code-edit").value = content;
+        document.querySelector("#language").value = body.language;
+        return createState(key, `${body.version === 0 ? "" : body.version}`, "view", content, body.language);
+    }
 
     document.querySelector("#code-view").innerHTML = body.formatted;
     document.querySelector("#code-style").innerHTML = body.css;
@@ -307,6 +457,142 @@ async function fetchDocument(key, version, language) {
     return createState(key, `${body.version === 0 ? "" : body.version}`, "view", body.data, body.language);
 }
 
+async function fetchVersions(key, version) {
+    const response = await fetch(`/documents/${key}/versions`, {
+        method: "GET",
+        headers: getHeaders(key)
+    });
+
+    const body = await response.json();
+    if (!response.ok) {
+        showErrorPopup(body.message || response.statusText);
+        console.error("error fetching document versions:", response);
+        return;
+    }
+
+    const versionElement = document.querySelector("#version");
+    versionElement.innerHTML = "";
+    for (const documentVersion of body) {
+        const optionElement = document.createElement("option");
+        optionElement.value = documentVersion.version;
+        optionElement.innerText = new Date(documentVersion.created_at * 1000).toLocaleString();
+        versionElement.appendChild(optionElement);
+    }
+    versionElement.value = version || body[0].version;
+}
+
+function getEncryptionKey() {
+    return new URLSearchParams(window.location.hash.slice(1)).get("key") || "";
+}
+
+async function decryptDocument(content) {
+    const encryptionKey = getEncryptionKey();
+    if (!encryptionKey) {
+        showErrorPopup("This document is encrypted, the key is missing in the URL.");
+        return "";
+    }
+    try {
+        content = await decryptContent(encryptionKey, content);
+    } catch (e) {
+        showErrorPopup("Failed to decrypt document, the key in the URL is invalid.");
+        console.error("error decrypting document:", e);
+        return "";
+    }
+    document.querySelector("#code-view").textContent = content;
+    return content;
+}
+
+// encryption keys are base64url encoded AES-256 keys, encrypted content is the base64 encoded nonce followed by the AES-GCM ciphertext
+async function generateEncryptionKey() {
+    const key = await crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt", "decrypt"]);
+    const rawKey = new Uint8Array(await crypto.subtle.exportKey("raw", key));
+    return bytesToBase64(rawKey).replaceAll("+", "-").replaceAll("/", "_").replaceAll("=", "");
+}
+
+async function importEncryptionKey(encryptionKey) {
+    const rawKey = base64ToBytes(encryptionKey.replaceAll("-", "+").replaceAll("_", "/"));
+    return crypto.subtle.importKey("raw", rawKey, "AES-GCM", false, ["encrypt", "decrypt"]);
+}
+
+async function encryptContent(encryptionKey, content) {
+    const key = await importEncryptionKey(encryptionKey);
+    const nonce = crypto.getRandomValues(new Uint8Array(12));
+    const ciphertext = new Uint8Array(await crypto.subtle.encrypt({name: "AES-GCM", iv: nonce}, key, new TextEncoder().encode(content)));
+    const data = new Uint8Array(nonce.length + ciphertext.length);
+    data.set(nonce);
+    data.set(ciphertext, nonce.length);
+    return bytesToBase64(data);
+}
+
+async function decryptContent(encryptionKey, content) {
+    const key = await importEncryptionKey(encryptionKey);
+    const data = base64ToBytes(content);
+    const plaintext = await crypto.subtle.decrypt({name: "AES-GCM", iv: data.slice(0, 12)}, key, data.slice(12));
+    return new TextDecoder().decode(plaintext);
+}
+
+function bytesToBase64(bytes) {
+    let binary = "";
+    for (const byte of bytes) {
+        binary += String.fromCharCode(byte);
+    }
+    return btoa(binary);
+}
+
+function base64ToBytes(base64) {
+    while (base64.length % 4 !== 0) {
+        base64 += "=";
+    }
+    return Uint8Array.from(atob(base64), c => c.charCodeAt(0));
+}
+
+// changes are only applied if nobody saved a new version since the latest version we know of
+function getIfMatchHeaders(key, token) {
+    const headers = {Authorization: `Bearer ${token}`};
+    const latestVersion = document.querySelector("#version").options.item(0)?.value;
+    if (latestVersion) {
+        headers["If-Match"] = `"${key}-${latestVersion}"`;
+    }
+    return headers;
+}
+
+function getHeaders(key) {
+    const headers = {};
+    const token = getToken(key);
+    if (token) {
+        headers["Authorization"] = `Bearer ${token}`;
+    }
+    const password = getPassword(key);
+    if (password) {
+        headers["X-Password"] = password;
+    }
+    return headers;
+}
+
+function updateExpiresAt(expiresAt) {
+    const expiresElement = document.querySelector("#expires");
+    const optionElement = expiresElement.options.item(0);
+    if (expiresAt) {
+        const expiresAtDate = new Date(expiresAt * 1000);
+        optionElement.title = expiresAtDate.toLocaleString();
+        optionElement.innerText = `expires ${expiresAtDate.toLocaleString()}`;
+    } else {
+        optionElement.title = "";
+        optionElement.innerText = "never expires";
+    }
+    expiresElement.value = "";
+}
+
+function updateRenderError(renderError) {
+    const renderErrorElement = document.querySelector("#render-error");
+    if (renderError) {
+        renderErrorElement.innerText = `The ${renderError}, so it's shown as plaintext.`;
+        renderErrorElement.style.display = "block";
+    } else {
+        renderErrorElement.style.display = "none";
+    }
+}
+
 function showErrorPopup(message) {
     const popup = document.getElementById("error-popup");
     popup.style.display = "block";
@@ -350,6 +636,24 @@ function deleteToken() {
     localStorage.setItem("documents", JSON.stringify(parsedDocuments));
 }
 
+function getPassword(key) {
+    const passwords = sessionStorage.getItem("passwords");
+    if (!passwords) return "";
+    return JSON.parse(passwords)[key] || "";
+}
+
+function setPassword(key, password) {
+    const passwords = JSON.parse(sessionStorage.getItem("passwords") || "{}");
+    passwords[key] = password;
+    sessionStorage.setItem("passwords", JSON.stringify(passwords));
+}
+
+function deletePassword(key) {
+    const passwords = JSON.parse(sessionStorage.getItem("passwords") || "{}");
+    delete passwords[key];
+    sessionStorage.setItem("passwords", JSON.stringify(passwords));
+}
+
 function hasPermission(token, permission) {
     if (!token) return false;
     const tokenSplit = token.split(".")
@@ -392,6 +696,22 @@ function updatePage(state) {
     const shareButton = document.querySelector("#share");
     const versionSelect = document.querySelector("#version");
     versionSelect.disabled = versionSelect.options.length <= 1;
+    const diffLink = document.querySelector("#diff-link");
+    diffLink.href = `/${key}/diff${state.version ? `?to=${state.version}` : ""}`;
+    diffLink.style.display = versionSelect.options.length <= 1 || document.querySelector("#password-form") || document.querySelector("#private-message") || document.querySelector("#private").checked ? "none" : "block";
+    document.querySelector("#expires").disabled = mode === "view";
+    // the encryption of existing documents can't be changed
+    document.querySelector("#encrypt").disabled = mode === "view" || key !== "";
+    document.querySelector("#private").disabled = mode === "view";
+    document.querySelector("#public").disabled = mode === "view";
+    document.querySelector("#search").disabled = false;
+    if (document.querySelector("#password-form") || document.querySelector("#private-message")) {
+        // the document is locked until the password has been entered or it has been fetched with a token
+        for (const button of [saveButton, editButton, deleteButton, copyButton, rawButton, shareButton]) {
+            button.disabled = true;
+        }
+        return;
+    }
     if (mode === "view") {
         saveButton.disabled = true;
         saveButton.style.display = "none";
@@ -16,6 +16,8 @@ func main() {
 	rootCmd := cmd.NewRootCmd()
 	cmd.NewGetCmd(rootCmd)
 	cmd.NewPushCmd(rootCmd)
+	cmd.NewDiffCmd(rootCmd)
+	cmd.NewSearchCmd(rootCmd)
 	cmd.NewRmCmd(rootCmd)
 	cmd.NewVersionCmd(rootCmd, gobin.FormatBuildVersion(version, commit, buildTime))
 	cmd.Execute(rootCmd)
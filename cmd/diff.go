@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/topisenpai/gobin/gobin"
+	"github.com/topisenpai/gobin/internal/ezhttp"
+)
+
+func NewDiffCmd(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "diff",
+		GroupID: "actions",
+		Short:   "Shows the differences between two document versions",
+		Example: `gobin diff jis74978
+
+Will show the changes of the latest version of the document with the id of jis74978.
+
+gobin diff jis74978 --from 1 --to 3
+
+Will show the changes between two versions of the document with the id of jis74978.
+
+gobin diff jis74978 abc12345
+
+Will show the differences between the latest versions of the documents with the ids of jis74978 and abc12345.`,
+		Args: cobra.RangeArgs(1, 2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			tokensMap := viper.GetStringMap("tokens.")
+			tokens := make([]string, 0, len(tokensMap))
+			for document := range tokensMap {
+				tokens = append(tokens, document)
+			}
+			return tokens, cobra.ShellCompDirectiveNoFileComp
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			viper.BindPFlag("server", cmd.PersistentFlags().Lookup("server"))
+			viper.BindPFlag("from", cmd.Flags().Lookup("from"))
+			viper.BindPFlag("to", cmd.Flags().Lookup("to"))
+			viper.BindPFlag("formatter", cmd.Flags().Lookup("formatter"))
+			viper.BindPFlag("style", cmd.Flags().Lookup("style"))
+			viper.BindPFlag("password", cmd.Flags().Lookup("password"))
+			viper.BindPFlag("token", cmd.Flags().Lookup("token"))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			documentID := args[0]
+			from := viper.GetString("from")
+			to := viper.GetString("to")
+			formatter := viper.GetString("formatter")
+			style := viper.GetString("style")
+			password := viper.GetString("password")
+			token := viper.GetString("token")
+			if token == "" {
+				token = viper.GetString("tokens_" + documentID)
+			}
+
+			query := url.Values{}
+			if len(args) > 1 {
+				query.Set("to_document", args[1])
+			}
+			if from != "" {
+				query.Set("from", from)
+			}
+			if to != "" {
+				query.Set("to", to)
+			}
+			if formatter != "" {
+				query.Set("formatter", formatter)
+				if style != "" {
+					query.Set("style", style)
+				}
+			}
+
+			path := "/documents/" + documentID + "/diff"
+			if len(query) > 0 {
+				path += "?" + query.Encode()
+			}
+
+			rs, err := getWithPassword(cmd, path, token, password)
+			if err != nil {
+				cmd.PrintErrln("Failed to get document diff:", err)
+				return
+			}
+			defer rs.Body.Close()
+
+			var diffRs gobin.DiffResponse
+			if ok := ezhttp.ProcessBody(cmd, "get document diff", rs, &diffRs); !ok {
+				return
+			}
+
+			if diffRs.Data == "" {
+				cmd.Println("No differences found")
+				return
+			}
+			if formatter != "" {
+				cmd.Println(string(diffRs.Formatted))
+				return
+			}
+			cmd.Print(diffRs.Data)
+		},
+	}
+
+	parent.AddCommand(cmd)
+
+	cmd.Flags().StringP("server", "s", "", "Gobin server address")
+	cmd.Flags().StringP("from", "", "", "The version to compare from, defaults to the version before --to")
+	cmd.Flags().StringP("to", "", "", "The version to compare to, defaults to the latest version")
+	cmd.Flags().StringP("formatter", "r", "", "Format the diff with syntax highlighting (terminal8, terminal16, terminal256, terminal16m, html, html-standalone, svg, or none)")
+	cmd.Flags().StringP("style", "", "", "The style to render the diff with")
+	cmd.Flags().StringP("password", "p", "", "The password of the documents, you will be prompted for it if a document is password protected")
+	cmd.Flags().StringP("token", "t", "", "The token to read a private document with, defaults to the token saved when pushing the document")
+}
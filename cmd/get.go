@@ -1,14 +1,21 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/alecthomas/chroma/v2/quick"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 
 	"github.com/topisenpai/gobin/gobin"
+	"github.com/topisenpai/gobin/internal/cfg"
+	"github.com/topisenpai/gobin/internal/e2ee"
 	"github.com/topisenpai/gobin/internal/ezhttp"
 )
 
@@ -37,6 +44,9 @@ Will return the document with the id of jis74978.`,
 			viper.BindPFlag("formatter", cmd.Flags().Lookup("formatter"))
 			viper.BindPFlag("language", cmd.Flags().Lookup("language"))
 			viper.BindPFlag("style", cmd.Flags().Lookup("style"))
+			viper.BindPFlag("password", cmd.Flags().Lookup("password"))
+			viper.BindPFlag("key", cmd.Flags().Lookup("key"))
+			viper.BindPFlag("token", cmd.Flags().Lookup("token"))
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) == 0 {
@@ -50,10 +60,17 @@ Will return the document with the id of jis74978.`,
 			formatter := viper.GetString("formatter")
 			language := viper.GetString("language")
 			style := viper.GetString("style")
+			password := viper.GetString("password")
+			encryptionKey := viper.GetString("key")
+			token := viper.GetString("token")
+			if token == "" {
+				// private documents can only be read with a token
+				token = viper.GetString("tokens_" + documentID)
+			}
 
 			if versions {
 				url := "/documents/" + documentID + "/versions"
-				rs, err := ezhttp.Get(url)
+				rs, err := getWithPassword(cmd, url, token, password)
 				if err != nil {
 					cmd.PrintErrln("Failed to get document versions:", err)
 					return
@@ -68,7 +85,7 @@ Will return the document with the id of jis74978.`,
 				now := time.Now()
 				var documentVersions string
 				for _, documentVersion := range documentVersionsRs {
-					relative, _ := gobin.FormatDocumentVersion(now, documentVersion.Version)
+					relative, _ := gobin.FormatDocumentVersion(now, documentVersion.CreatedAt)
 					documentVersions += fmt.Sprintf("%d: %s\n", documentVersion.Version, relative)
 				}
 
@@ -90,7 +107,7 @@ Will return the document with the id of jis74978.`,
 				}
 			}
 
-			rs, err := ezhttp.Get(url)
+			rs, err := getWithPassword(cmd, url, token, password)
 			if err != nil {
 				cmd.PrintErrln("Failed to get document:", err)
 				return
@@ -101,9 +118,33 @@ Will return the document with the id of jis74978.`,
 			if ok := ezhttp.ProcessBody(cmd, "get document", rs, &documentRs); !ok {
 				return
 			}
+			if version == "" {
+				saveLatestVersion(cmd, documentID, rs.Header.Get("ETag"))
+			}
 
 			data := documentRs.Data
-			if formatter != "" {
+			if documentRs.Encrypted {
+				if encryptionKey == "" {
+					encryptionKey = viper.GetString("keys_" + documentID)
+				}
+				if encryptionKey == "" {
+					cmd.PrintErrln("Document is encrypted, no key found or provided for document:", documentID)
+					return
+				}
+				if data, err = e2ee.Decrypt(encryptionKey, data); err != nil {
+					cmd.PrintErrln("Failed to decrypt document:", err)
+					return
+				}
+				// the server can't render encrypted documents, so we do it ourselves
+				if formatter != "" {
+					buff := new(bytes.Buffer)
+					if err = quick.Highlight(buff, data, documentRs.Language, formatter, style); err != nil {
+						cmd.PrintErrln("Failed to render document:", err)
+						return
+					}
+					data = buff.String()
+				}
+			} else if formatter != "" {
 				data = string(documentRs.Formatted)
 			}
 
@@ -136,4 +177,41 @@ Will return the document with the id of jis74978.`,
 	cmd.Flags().StringP("formatter", "r", "", "Format the document with syntax highlighting (terminal8, terminal16, terminal256, terminal16m, html, html-standalone, svg, or none)")
 	cmd.Flags().StringP("language", "l", "", "The language to render the document with")
 	cmd.Flags().StringP("style", "", "", "The style to render the document with")
+	cmd.Flags().StringP("password", "p", "", "The password of the document, you will be prompted for it if the document is password protected")
+	cmd.Flags().StringP("key", "k", "", "The key to decrypt the document with, defaults to the key saved when pushing the document")
+	cmd.Flags().StringP("token", "t", "", "The token to read private documents with, defaults to the token saved when pushing the document")
+}
+
+// saveLatestVersion saves the latest version of a document we own, so the next push of the document is based on it.
+func saveLatestVersion(cmd *cobra.Command, documentID string, etag string) {
+	etagDocumentID, version, ok := gobin.ParseVersionETag(etag)
+	if !ok || etagDocumentID != documentID {
+		return
+	}
+	latestVersion := strconv.FormatInt(version, 10)
+	if viper.GetString("tokens_"+documentID) == "" || viper.GetString("versions_"+documentID) == latestVersion {
+		return
+	}
+	if _, err := cfg.Update(func(m map[string]string) {
+		m["VERSIONS_"+documentID] = latestVersion
+	}); err != nil {
+		cmd.PrintErrln("Failed to update config:", err)
+	}
+}
+
+// getWithPassword gets the path and prompts for the document password if the server requires one which wasn't provided.
+func getWithPassword(cmd *cobra.Command, path string, token string, password string) (*http.Response, error) {
+	rs, err := ezhttp.Get(path, token, password)
+	if err != nil || rs.StatusCode != http.StatusUnauthorized || password != "" || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return rs, err
+	}
+	_ = rs.Body.Close()
+
+	cmd.Print("Password: ")
+	passwordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	cmd.Println()
+	if err != nil {
+		return nil, err
+	}
+	return ezhttp.Get(path, token, string(passwordBytes))
 }
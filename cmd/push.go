@@ -1,16 +1,24 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
+	"strconv"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/topisenpai/gobin/gobin"
 	"github.com/topisenpai/gobin/internal/cfg"
+	"github.com/topisenpai/gobin/internal/e2ee"
 	"github.com/topisenpai/gobin/internal/ezhttp"
 )
 
@@ -29,12 +37,35 @@ Will push "hello world!" to the server`,
 			viper.BindPFlag("document", cmd.Flags().Lookup("document"))
 			viper.BindPFlag("token", cmd.Flags().Lookup("token"))
 			viper.BindPFlag("language", cmd.Flags().Lookup("language"))
+			viper.BindPFlag("expires", cmd.Flags().Lookup("expires"))
+			viper.BindPFlag("max-views", cmd.Flags().Lookup("max-views"))
+			viper.BindPFlag("password", cmd.Flags().Lookup("password"))
+			viper.BindPFlag("remove-password", cmd.Flags().Lookup("remove-password"))
+			viper.BindPFlag("encrypt", cmd.Flags().Lookup("encrypt"))
+			viper.BindPFlag("private", cmd.Flags().Lookup("private"))
+			viper.BindPFlag("public", cmd.Flags().Lookup("public"))
+			viper.BindPFlag("base-version", cmd.Flags().Lookup("base-version"))
+			viper.BindPFlag("force", cmd.Flags().Lookup("force"))
+			viper.BindPFlag("compress", cmd.Flags().Lookup("compress"))
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			file := viper.GetString("file")
 			documentID := viper.GetString("document")
 			token := viper.GetString("token")
 			language := viper.GetString("language")
+			expires := viper.GetString("expires")
+			maxViews := viper.GetInt("max-views")
+			password := viper.GetString("password")
+			removePassword := viper.GetBool("remove-password")
+			encrypt := viper.GetBool("encrypt")
+			baseVersion := viper.GetInt64("base-version")
+			force := viper.GetBool("force")
+			compression := viper.GetString("compress")
+
+			if removePassword && (documentID == "" || password != "") {
+				cmd.PrintErrln("--remove-password can only be used to update a document and not together with --password")
+				return
+			}
 
 			var (
 				r   io.Reader
@@ -76,14 +107,63 @@ Will push "hello world!" to the server`,
 				content = string(data)
 			}
 
-			contentReader := strings.NewReader(content)
+			query := url.Values{}
+			if language != "" {
+				query.Set("language", language)
+			}
+			if expires != "" {
+				query.Set("expires", expires)
+			}
+			if maxViews > 0 {
+				query.Set("max_views", strconv.Itoa(maxViews))
+			}
+			if cmd.Flags().Changed("private") {
+				query.Set("private", strconv.FormatBool(viper.GetBool("private")))
+			}
+			if cmd.Flags().Changed("public") {
+				query.Set("public", strconv.FormatBool(viper.GetBool("public")))
+			}
+			if removePassword {
+				query.Set("password", "none")
+			}
+
+			// updates of encrypted documents always have to be encrypted with the key of the document
+			var encryptionKey string
+			if documentID != "" {
+				encryptionKey = viper.GetString("keys_" + documentID)
+				if encrypt && encryptionKey == "" {
+					cmd.PrintErrln("No encryption key found for document:", documentID)
+					return
+				}
+			} else if encrypt {
+				encryptionKey, err = e2ee.NewKey()
+				if err != nil {
+					cmd.PrintErrln("Failed to generate encryption key:", err)
+					return
+				}
+				query.Set("encrypted", "true")
+			}
+			if encryptionKey != "" {
+				content, err = e2ee.Encrypt(encryptionKey, content)
+				if err != nil {
+					cmd.PrintErrln("Failed to encrypt document:", err)
+					return
+				}
+			}
+
+			body, err := compressContent(compression, content)
+			if err != nil {
+				cmd.PrintErrln("Failed to compress document:", err)
+				return
+			}
+			contentReader := bytes.NewReader(body)
 			var rs *http.Response
 			if documentID == "" {
 				path := "/documents"
-				if language != "" {
-					path += "?language=" + language
+				if len(query) > 0 {
+					path += "?" + query.Encode()
 				}
-				rs, err = ezhttp.Post(path, contentReader)
+				rs, err = ezhttp.Post(path, password, compression, contentReader)
 				if err != nil {
 					cmd.PrintErrln("Failed to create document:", err)
 					return
@@ -96,11 +176,19 @@ Will push "hello world!" to the server`,
 					cmd.PrintErrln("No token found or provided for document:", documentID)
 					return
 				}
+				if baseVersion == 0 {
+					baseVersion = viper.GetInt64("versions_" + documentID)
+				}
+				// the update fails if someone else changed the document since the version it's based on, instead of overwriting their changes
+				var ifMatch string
+				if baseVersion > 0 && !force {
+					ifMatch = gobin.VersionETag(documentID, baseVersion)
+				}
 				path := "/documents/" + documentID
-				if language != "" {
-					path += "?language=" + language
+				if len(query) > 0 {
+					path += "?" + query.Encode()
 				}
-				rs, err = ezhttp.Patch(path, token, contentReader)
+				rs, err = ezhttp.Patch(path, token, password, ifMatch, compression, contentReader)
 				if err != nil {
 					cmd.PrintErrln("Failed to update document:", err)
 					return
@@ -108,6 +196,17 @@ Will push "hello world!" to the server`,
 			}
 			defer rs.Body.Close()
 
+			if rs.StatusCode == http.StatusPreconditionFailed {
+				var errRs gobin.ErrorResponse
+				if err = json.NewDecoder(rs.Body).Decode(&errRs); err != nil {
+					cmd.PrintErrln("Failed to decode error response:", err)
+					return
+				}
+				cmd.PrintErrf("Document was changed since version %d, the current version is %d.\n", baseVersion, errRs.Version)
+				cmd.PrintErrf("Get it with \"gobin get %s\" and push your changes again, or use --force to overwrite it.\n", documentID)
+				return
+			}
+
 			var documentRs gobin.DocumentResponse
 			if ok := ezhttp.ProcessBody(cmd, "push document", rs, &documentRs); !ok {
 				return
@@ -117,20 +216,36 @@ Will push "hello world!" to the server`,
 			if documentID == "" {
 				method = "Created"
 			}
-			cmd.Printf("%s document with ID: %s, Version: %d, URL: %s/%s\n", method, documentRs.Key, documentRs.Version, viper.GetString("server"), documentRs.Key)
-
-			if documentID != "" {
-				return
+			documentURL := viper.GetString("server") + "/" + documentRs.Key
+			if encryptionKey != "" {
+				documentURL += "#key=" + encryptionKey
+			}
+			cmd.Printf("%s document with ID: %s, Version: %d, URL: %s\n", method, documentRs.Key, documentRs.Version, documentURL)
+			if documentRs.ExpiresAt > 0 {
+				cmd.Printf("Document expires at: %s\n", time.Unix(documentRs.ExpiresAt, 0).Format(time.RFC1123))
+			}
+			if documentRs.ViewsLeft != nil {
+				cmd.Printf("Document can be viewed %d times\n", *documentRs.ViewsLeft)
 			}
 
 			path, err := cfg.Update(func(m map[string]string) {
+				// the next update is based on the version we just pushed
+				m["VERSIONS_"+documentRs.Key] = strconv.FormatInt(documentRs.Version, 10)
+				if documentID != "" {
+					return
+				}
 				m["TOKENS_"+documentRs.Key] = documentRs.Token
+				if encryptionKey != "" {
+					m["KEYS_"+documentRs.Key] = encryptionKey
+				}
 			})
 			if err != nil {
 				cmd.PrintErrln("Failed to update config:", err)
 				return
 			}
-			cmd.Println("Saved token to:", path)
+			if documentID == "" {
+				cmd.Println("Saved token to:", path)
+			}
 		},
 	}
 
@@ -141,4 +256,41 @@ Will push "hello world!" to the server`,
 	cmd.Flags().StringP("document", "d", "", "The document to update")
 	cmd.Flags().StringP("token", "t", "", "The token for the document to update")
 	cmd.Flags().StringP("language", "l", "", "The language of the document")
+	cmd.Flags().StringP("expires", "e", "", "When the document expires (a duration like 24h, a RFC3339 timestamp or never)")
+	cmd.Flags().IntP("max-views", "m", 0, "How many times the document can be viewed before it gets deleted, 1 burns it after reading")
+	cmd.Flags().StringP("password", "p", "", "The password to protect the document with")
+	cmd.Flags().BoolP("remove-password", "", false, "Remove the password of the document to update")
+	cmd.Flags().BoolP("encrypt", "", false, "Encrypt the document before uploading it, the key is only part of the URL")
+	cmd.Flags().BoolP("private", "", false, "Only allow tokens with the read permission to view the document, use --private=false to make it public again")
+	cmd.Flags().BoolP("public", "", false, "List the document in search results of everyone, use --public=false to unlist it again")
+	cmd.Flags().Int64P("base-version", "", 0, "The version the update is based on, it fails if the document was changed since, defaults to the version last pushed or fetched")
+	cmd.Flags().BoolP("force", "", false, "Update the document even if it was changed since the version the update is based on")
+	cmd.Flags().StringP("compress", "c", "", "Compress the document for the upload with gzip or zstd, useful for big logs")
+}
+
+// compressContent compresses the content with gzip or zstd, the content is sent uncompressed if compression is empty.
+func compressContent(compression string, content string) ([]byte, error) {
+	switch compression {
+	case "":
+		return []byte(content), nil
+	case "gzip":
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(w, content); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll([]byte(content), nil), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q, must be one of: gzip, zstd", compression)
+	}
 }
@@ -81,6 +81,8 @@ Will delete the jis74978 from the server.`,
 
 			path, err = cfg.Update(func(m map[string]string) {
 				delete(m, "TOKENS_"+documentID)
+				delete(m, "KEYS_"+documentID)
+				delete(m, "VERSIONS_"+documentID)
 			})
 			if err != nil {
 				cmd.PrintErrln("Failed to update config:", err)
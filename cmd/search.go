@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/topisenpai/gobin/gobin"
+	"github.com/topisenpai/gobin/internal/ezhttp"
+)
+
+func NewSearchCmd(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:     "search",
+		GroupID: "actions",
+		Short:   "Searches your and public documents on the gobin server",
+		Example: `gobin search hello world
+
+Will search for documents containing hello and world.
+
+gobin search --language go
+
+Will list all Go documents.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			viper.BindPFlag("server", cmd.PersistentFlags().Lookup("server"))
+			viper.BindPFlag("language", cmd.Flags().Lookup("language"))
+			viper.BindPFlag("limit", cmd.Flags().Lookup("limit"))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			query := strings.Join(args, " ")
+			language := viper.GetString("language")
+			limit := viper.GetInt("limit")
+			if query == "" && language == "" {
+				cmd.PrintErrln("search query or language is required")
+				return
+			}
+
+			// send all saved tokens, so the server also searches our own documents
+			var tokens []string
+			for _, key := range viper.AllKeys() {
+				if strings.HasPrefix(key, "tokens_") {
+					tokens = append(tokens, viper.GetString(key))
+				}
+			}
+
+			body, err := json.Marshal(gobin.SearchRequest{
+				Query:    query,
+				Language: language,
+				Tokens:   tokens,
+				Limit:    limit,
+			})
+			if err != nil {
+				cmd.PrintErrln("Failed to encode search request:", err)
+				return
+			}
+
+			rs, err := ezhttp.Post("/documents/search", "", "", bytes.NewReader(body))
+			if err != nil {
+				cmd.PrintErrln("Failed to search documents:", err)
+				return
+			}
+			defer rs.Body.Close()
+
+			var searchRs []gobin.SearchResponse
+			if ok := ezhttp.ProcessBody(cmd, "search documents", rs, &searchRs); !ok {
+				return
+			}
+
+			if len(searchRs) == 0 {
+				cmd.Println("No documents found")
+				return
+			}
+			server := viper.GetString("server")
+			for _, result := range searchRs {
+				snippet := strings.Join(strings.Fields(result.Snippet), " ")
+				cmd.Printf("%s/%s (%s): %s\n", server, result.Key, result.Language, snippet)
+			}
+		},
+	}
+
+	parent.AddCommand(cmd)
+
+	cmd.Flags().StringP("server", "s", "", "Gobin server address")
+	cmd.Flags().StringP("language", "l", "", "Only search documents with this language")
+	cmd.Flags().IntP("limit", "", 20, "The maximum number of documents to return")
+}
@@ -35,7 +35,7 @@ OS/Arch: windows/amd64`,
 			cmd.Println(version)
 
 			if server != "" {
-				rs, err := ezhttp.Get("/version")
+				rs, err := ezhttp.Get("/version", "", "")
 				if err != nil {
 					cmd.PrintErrln("Failed to get server version:", err)
 					return
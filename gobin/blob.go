@@ -0,0 +1,185 @@
+package gobin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// BlobStore stores the content of document versions outside the database, referenced by the content_key of each version.
+type BlobStore interface {
+	Put(ctx context.Context, key string, content string) error
+	Get(ctx context.Context, key string) (string, error)
+	// Delete deletes the blobs with the given keys, missing blobs are ignored.
+	Delete(ctx context.Context, keys []string) error
+}
+
+// NewBlobStore creates the BlobStore of the configured content storage type.
+// It returns nil if content is stored in the database.
+func NewBlobStore(ctx context.Context, cfg ContentStorageConfig) (BlobStore, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "dir":
+		return NewDirBlobStore(cfg.Path)
+	case "s3":
+		return NewS3BlobStore(ctx, cfg)
+	default:
+		return nil, errors.New("invalid content storage type, must be one of: dir, s3")
+	}
+}
+
+// NewDirBlobStore creates a BlobStore which stores every blob as file in the directory.
+func NewDirBlobStore(dir string) (*DirBlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DirBlobStore{dir: dir}, nil
+}
+
+type DirBlobStore struct {
+	dir string
+}
+
+func (s *DirBlobStore) Put(_ context.Context, key string, content string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so readers never see a partially written blob
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.WriteString(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *DirBlobStore) Get(_ context.Context, key string) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (s *DirBlobStore) Delete(_ context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		path, err := s.path(key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		// remove the directory of the document once it's empty
+		_ = os.Remove(filepath.Dir(path))
+	}
+	return errors.Join(errs...)
+}
+
+// path returns the file path of a key and makes sure it can't escape the directory.
+func (s *DirBlobStore) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", errors.New("invalid blob key: " + key)
+	}
+	return path, nil
+}
+
+// NewS3BlobStore creates a BlobStore which stores every blob as object in an S3 compatible bucket.
+// The bucket is created if it doesn't exist.
+func NewS3BlobStore(ctx context.Context, cfg ContentStorageConfig) (*S3BlobStore, error) {
+	bucketLookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: bucketLookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3BlobStore{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+type S3BlobStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, content string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, strings.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType: "text/plain; charset=utf-8",
+	})
+	return err
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) (string, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	buff := new(bytes.Buffer)
+	if _, err = io.Copy(buff, object); err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			// S3 doesn't return an error for missing objects, but some stand-ins do
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,728 @@
+package gobin
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	boltDocumentsBucket     = []byte("documents")
+	boltContentsBucket      = []byte("contents")
+	boltRevokedTokensBucket = []byte("revoked_tokens")
+)
+
+// NewBoltDB opens or creates the bbolt database file and starts the cleanup.
+func NewBoltDB(cfg DatabaseConfig, metrics *Metrics) (*BoltDB, error) {
+	bolt, err := bbolt.Open(cfg.Path, 0600, &bbolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = bolt.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltDocumentsBucket, boltContentsBucket, boltRevokedTokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return boltNumberVersions(tx)
+	}); err != nil {
+		_ = bolt.Close()
+		return nil, err
+	}
+
+	db := &BoltDB{
+		bolt:    bolt,
+		metrics: metrics,
+	}
+	db.cleanup = startCleanup(db, cfg, metrics)
+	return db, nil
+}
+
+// BoltDB is the Store backed by an embedded bbolt key-value database.
+// Every document has its own bucket in the documents bucket holding its versions as json keyed by the big endian version, so versions are ordered from oldest to newest.
+// The sequence of the document bucket holds the last version of the document.
+// The content of versions is stored once per hash in the contents bucket, which counts the versions referencing it.
+// Token revocations are stored the same way in the revoked_tokens bucket keyed by the token id.
+type BoltDB struct {
+	bolt      *bbolt.DB
+	metrics   *Metrics
+	cleanup   *cleanupLoop
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type boltContent struct {
+	Content string `json:"content"`
+	Refs    int64  `json:"refs"`
+}
+
+type boltRevocation struct {
+	RevokedAt int64  `json:"revoked_at"`
+	ExpiresAt *int64 `json:"expires_at"`
+}
+
+// Close stops the cleanup, waits for a running cleanup to finish and closes the database.
+func (d *BoltDB) Close() error {
+	d.closeOnce.Do(func() {
+		d.cleanup.stop()
+		d.closeErr = d.bolt.Close()
+	})
+	return d.closeErr
+}
+
+func (d *BoltDB) GetDocument(_ context.Context, documentID string) (Document, error) {
+	defer d.metrics.ObserveQuery("get_document", time.Now())
+	var doc Document
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+		_, v := bucket.Cursor().Last()
+		var err error
+		if doc, err = boltGetDocument(tx, v, true); err == nil && expired(doc, time.Now()) {
+			return sql.ErrNoRows
+		}
+		return err
+	})
+	return doc, err
+}
+
+func (d *BoltDB) GetDocumentVersion(_ context.Context, documentID string, version int64) (Document, error) {
+	defer d.metrics.ObserveQuery("get_document_version", time.Now())
+	var doc Document
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+		_, v, err := boltFindVersion(bucket, version)
+		if err != nil {
+			return err
+		}
+		if doc, err = boltGetDocument(tx, v, true); err == nil && expired(doc, time.Now()) {
+			return sql.ErrNoRows
+		}
+		return err
+	})
+	return doc, err
+}
+
+func (d *BoltDB) GetDocumentVersions(_ context.Context, documentID string, withContent bool) ([]Document, error) {
+	defer d.metrics.ObserveQuery("get_document_versions", time.Now())
+	var docs []Document
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return nil
+		}
+		now := time.Now()
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			doc, err := boltGetDocument(tx, v, withContent)
+			if err != nil {
+				return err
+			}
+			if expired(doc, now) {
+				continue
+			}
+			if !withContent {
+				doc.Language = ""
+			}
+			docs = append(docs, doc)
+		}
+		return nil
+	})
+	return docs, err
+}
+
+func (d *BoltDB) GetVersionCount(_ context.Context, documentID string) (int, error) {
+	defer d.metrics.ObserveQuery("get_version_count", time.Now())
+	var count int
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		if bucket := boltDocument(tx, documentID); bucket != nil {
+			count = bucket.Stats().KeyN
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (d *BoltDB) CreateDocument(_ context.Context, document Document) (Document, error) {
+	defer d.metrics.ObserveQuery("create_document", time.Now())
+	hash := contentHash(document.Content)
+	var doc Document
+	err := d.bolt.Update(func(tx *bbolt.Tx) error {
+		documents := tx.Bucket(boltDocumentsBucket)
+		// bbolt only allows one write transaction at a time, so checking for the id before creating it is atomic
+		for try := 0; try < 10; try++ {
+			doc = document
+			doc.ID = randomString(8)
+			if documents.Bucket([]byte(doc.ID)) != nil {
+				continue
+			}
+			bucket, err := documents.CreateBucket([]byte(doc.ID))
+			if err != nil {
+				return err
+			}
+			version, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			doc.Version = int64(version)
+			doc.CreatedAt = time.Now().Unix()
+			doc.LegacyVersion = nil
+			doc.ContentHash = hash
+			if err = boltAddContent(tx, hash, doc.Content); err != nil {
+				return err
+			}
+			return boltPutDocument(bucket, doc)
+		}
+		return errors.New("failed to create document because of duplicate key after 10 tries")
+	})
+	if err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+func (d *BoltDB) UpdateDocument(_ context.Context, documentID string, content string, language string, matchVersion int64, update DocumentUpdate) (Document, error) {
+	defer d.metrics.ObserveQuery("update_document", time.Now())
+	hash := contentHash(content)
+	var doc Document
+	err := d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+		// the new version inherits the expiration, views, password & encryption of the latest version
+		_, v := bucket.Cursor().Last()
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return err
+		}
+		if matchVersion != 0 && doc.Version != matchVersion {
+			return ErrVersionConflict
+		}
+		if err := boltUpdateVersions(bucket, func(doc *Document) bool {
+			update.apply(doc)
+			return true
+		}); err != nil {
+			return err
+		}
+		update.apply(&doc)
+		version, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		doc.Version = int64(version)
+		doc.CreatedAt = time.Now().Unix()
+		doc.LegacyVersion = nil
+		doc.Content = content
+		doc.ContentHash = hash
+		doc.Language = language
+		if err := boltAddContent(tx, hash, content); err != nil {
+			return err
+		}
+		return boltPutDocument(bucket, doc)
+	})
+	if err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+func (d *BoltDB) UpdateDocumentExpiration(_ context.Context, documentID string, expiresAt *int64) error {
+	defer d.metrics.ObserveQuery("update_document_expiration", time.Now())
+	return d.updateDocument(documentID, func(doc *Document) {
+		doc.ExpiresAt = expiresAt
+	})
+}
+
+func (d *BoltDB) UpdateDocumentPassword(_ context.Context, documentID string, passwordHash *string) error {
+	defer d.metrics.ObserveQuery("update_document_password", time.Now())
+	return d.updateDocument(documentID, func(doc *Document) {
+		doc.PasswordHash = passwordHash
+	})
+}
+
+func (d *BoltDB) UpdateDocumentVisibility(_ context.Context, documentID string, private bool, public bool) error {
+	defer d.metrics.ObserveQuery("update_document_visibility", time.Now())
+	return d.updateDocument(documentID, func(doc *Document) {
+		doc.Private = private
+		doc.Public = public
+	})
+}
+
+// updateDocument updates the metadata of all versions of a document.
+func (d *BoltDB) updateDocument(documentID string, update func(doc *Document)) error {
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+		return boltUpdateVersions(bucket, func(doc *Document) bool {
+			update(doc)
+			return true
+		})
+	})
+}
+
+func (d *BoltDB) ViewDocument(_ context.Context, documentID string) (int64, error) {
+	defer d.metrics.ObserveQuery("view_document", time.Now())
+	var viewsLeft int64
+	err := d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+
+		var viewed bool
+		if err := boltUpdateVersions(bucket, func(doc *Document) bool {
+			if doc.ViewsLeft == nil || *doc.ViewsLeft <= 0 {
+				return false
+			}
+			left := *doc.ViewsLeft - 1
+			doc.ViewsLeft = &left
+			if !viewed {
+				viewed = true
+				viewsLeft = left
+			}
+			return true
+		}); err != nil {
+			return err
+		}
+		if !viewed {
+			return sql.ErrNoRows
+		}
+		if viewsLeft <= 0 {
+			return boltDeleteDocument(tx, documentID)
+		}
+		return nil
+	})
+	return viewsLeft, err
+}
+
+func (d *BoltDB) DeleteDocument(_ context.Context, documentID string, matchVersion int64) error {
+	defer d.metrics.ObserveQuery("delete_document", time.Now())
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+		if matchVersion != 0 {
+			if k, _ := bucket.Cursor().Last(); !bytes.Equal(k, boltVersionKey(matchVersion)) {
+				return ErrVersionConflict
+			}
+		}
+		return boltDeleteDocument(tx, documentID)
+	})
+}
+
+func (d *BoltDB) DeleteDocumentByVersion(_ context.Context, documentID string, version int64) error {
+	defer d.metrics.ObserveQuery("delete_document_by_version", time.Now())
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := boltDocument(tx, documentID)
+		if bucket == nil {
+			return sql.ErrNoRows
+		}
+		key, _, err := boltFindVersion(bucket, version)
+		if err != nil {
+			return err
+		}
+		if err = boltDeleteVersion(tx, bucket, key); err != nil {
+			return err
+		}
+		if k, _ := bucket.Cursor().First(); k == nil {
+			return tx.Bucket(boltDocumentsBucket).DeleteBucket([]byte(documentID))
+		}
+		return nil
+	})
+}
+
+// SearchDocuments matches documents containing all terms of the query case-insensitively.
+// Unlike the SQL databases results are not ranked by relevance but ordered by creation time.
+func (d *BoltDB) SearchDocuments(_ context.Context, opts SearchOptions) ([]SearchResult, error) {
+	defer d.metrics.ObserveQuery("search_documents", time.Now())
+	terms := searchTerms(opts.Query)
+	now := time.Now()
+
+	var results []SearchResult
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltDocumentsBucket).ForEach(func(k []byte, _ []byte) error {
+			_, v := tx.Bucket(boltDocumentsBucket).Bucket(k).Cursor().Last()
+			doc, err := boltGetDocument(tx, v, true)
+			if err != nil || expired(doc, now) {
+				return err
+			}
+			if result, ok := searchDocument(doc, opts, terms); ok {
+				results = append(results, result)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return limitSearchResults(results, opts.Limit), nil
+}
+
+func (d *BoltDB) RevokeToken(_ context.Context, documentID string, tokenID string, expiresAt *int64) error {
+	defer d.metrics.ObserveQuery("revoke_token", time.Now())
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(boltRevokedTokensBucket).CreateBucketIfNotExists([]byte(documentID))
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(tokenID)) != nil {
+			return nil
+		}
+		return boltPutRevocation(bucket, tokenID, boltRevocation{
+			RevokedAt: time.Now().Unix(),
+			ExpiresAt: expiresAt,
+		})
+	})
+}
+
+func (d *BoltDB) RevokeAllTokens(_ context.Context, documentID string, revokedAt int64) error {
+	defer d.metrics.ObserveQuery("revoke_all_tokens", time.Now())
+	return d.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(boltRevokedTokensBucket).CreateBucketIfNotExists([]byte(documentID))
+		if err != nil {
+			return err
+		}
+		return boltPutRevocation(bucket, allTokensID, boltRevocation{
+			RevokedAt: revokedAt,
+		})
+	})
+}
+
+func (d *BoltDB) IsTokenRevoked(_ context.Context, documentID string, tokenID string, issuedAt int64) (bool, error) {
+	defer d.metrics.ObserveQuery("is_token_revoked", time.Now())
+	var revoked bool
+	err := d.bolt.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltRevokedTokensBucket).Bucket([]byte(documentID))
+		if bucket == nil {
+			return nil
+		}
+		if bucket.Get([]byte(tokenID)) != nil {
+			revoked = true
+			return nil
+		}
+		v := bucket.Get([]byte(allTokensID))
+		if v == nil {
+			return nil
+		}
+		var all boltRevocation
+		if err := json.Unmarshal(v, &all); err != nil {
+			return err
+		}
+		revoked = all.RevokedAt > issuedAt
+		return nil
+	})
+	return revoked, err
+}
+
+func (d *BoltDB) DeleteExpiredDocuments(_ context.Context, expireAfter time.Duration) (int64, error) {
+	defer d.metrics.ObserveQuery("delete_expired_documents", time.Now())
+	now := time.Now()
+	var minCreatedAt int64
+	if expireAfter > 0 {
+		minCreatedAt = now.Add(-expireAfter).Unix()
+	}
+
+	var deleted int64
+	err := d.bolt.Update(func(tx *bbolt.Tx) error {
+		documents := tx.Bucket(boltDocumentsBucket)
+		var empty [][]byte
+		if err := documents.ForEach(func(k []byte, _ []byte) error {
+			bucket := documents.Bucket(k)
+			var expired [][]byte
+			if err := bucket.ForEach(func(version []byte, v []byte) error {
+				var doc Document
+				if err := json.Unmarshal(v, &doc); err != nil {
+					return err
+				}
+				if (doc.ExpiresAt != nil && *doc.ExpiresAt <= now.Unix()) || (doc.ExpiresAt == nil && doc.CreatedAt < minCreatedAt) {
+					expired = append(expired, version)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for _, version := range expired {
+				if err := boltDeleteVersion(tx, bucket, version); err != nil {
+					return err
+				}
+				deleted++
+			}
+			if key, _ := bucket.Cursor().First(); key == nil {
+				empty = append(empty, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// buckets can't be modified while iterating over them
+		for _, k := range empty {
+			if err := documents.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (d *BoltDB) DeleteExpiredTokenRevocations(_ context.Context) (int64, error) {
+	defer d.metrics.ObserveQuery("delete_expired_token_revocations", time.Now())
+	now := time.Now().Unix()
+
+	var deleted int64
+	err := d.bolt.Update(func(tx *bbolt.Tx) error {
+		documents := tx.Bucket(boltDocumentsBucket)
+		revokedTokens := tx.Bucket(boltRevokedTokensBucket)
+		var empty [][]byte
+		if err := revokedTokens.ForEach(func(documentID []byte, _ []byte) error {
+			bucket := revokedTokens.Bucket(documentID)
+			exists := documents.Bucket(documentID) != nil
+
+			var expired [][]byte
+			if err := bucket.ForEach(func(tokenID []byte, v []byte) error {
+				var revocation boltRevocation
+				if err := json.Unmarshal(v, &revocation); err != nil {
+					return err
+				}
+				if !exists || (revocation.ExpiresAt != nil && *revocation.ExpiresAt <= now) {
+					expired = append(expired, tokenID)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for _, tokenID := range expired {
+				if err := bucket.Delete(tokenID); err != nil {
+					return err
+				}
+				deleted++
+			}
+			if k, _ := bucket.Cursor().First(); k == nil {
+				empty = append(empty, documentID)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, documentID := range empty {
+			if err := revokedTokens.DeleteBucket(documentID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func boltDocument(tx *bbolt.Tx, documentID string) *bbolt.Bucket {
+	return tx.Bucket(boltDocumentsBucket).Bucket([]byte(documentID))
+}
+
+// boltVersionKey encodes the version big endian, so keys are sorted by version.
+func boltVersionKey(version int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(version))
+	return key
+}
+
+// boltFindVersion returns the key and value of a version by its number or by its legacy version, like GetDocumentVersion of the SQL databases.
+func boltFindVersion(bucket *bbolt.Bucket, version int64) ([]byte, []byte, error) {
+	key := boltVersionKey(version)
+	if v := bucket.Get(key); v != nil {
+		return key, v, nil
+	}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var doc Document
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return nil, nil, err
+		}
+		if doc.LegacyVersion != nil && *doc.LegacyVersion == version {
+			return k, v, nil
+		}
+	}
+	return nil, nil, sql.ErrNoRows
+}
+
+// boltNumberVersions numbers the versions of documents created before versions were numbered, which used the unix second they were created at as version.
+// The old version is kept as legacy version, so links to it keep working. New document buckets always have a sequence, so documents without one are numbered.
+func boltNumberVersions(tx *bbolt.Tx) error {
+	documents := tx.Bucket(boltDocumentsBucket)
+	return documents.ForEach(func(k []byte, _ []byte) error {
+		bucket := documents.Bucket(k)
+		if bucket.Sequence() != 0 {
+			return nil
+		}
+		var docs []Document
+		if err := bucket.ForEach(func(_ []byte, v []byte) error {
+			var doc Document
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+			return nil
+		}); err != nil {
+			return err
+		}
+		// modifying a bucket while iterating over it is not allowed
+		for i, doc := range docs {
+			if err := bucket.Delete(boltVersionKey(doc.Version)); err != nil {
+				return err
+			}
+			legacyVersion := doc.Version
+			docs[i].Version = int64(i + 1)
+			docs[i].CreatedAt = legacyVersion
+			docs[i].LegacyVersion = &legacyVersion
+		}
+		for _, doc := range docs {
+			if err := boltPutDocument(bucket, doc); err != nil {
+				return err
+			}
+		}
+		return bucket.SetSequence(uint64(len(docs)))
+	})
+}
+
+// boltGetDocument decodes a version and loads its content from the contents bucket if withContent is true.
+func boltGetDocument(tx *bbolt.Tx, v []byte, withContent bool) (Document, error) {
+	var doc Document
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return Document{}, err
+	}
+	if !withContent {
+		return doc, nil
+	}
+	content, err := boltGetContent(tx, doc.ContentHash)
+	if err != nil {
+		return Document{}, err
+	}
+	doc.Content = content.Content
+	return doc, nil
+}
+
+// boltPutDocument stores a version without its content, which has to be added to the contents bucket with boltAddContent for new versions.
+func boltPutDocument(bucket *bbolt.Bucket, doc Document) error {
+	doc.Content = ""
+	v, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(boltVersionKey(doc.Version), v)
+}
+
+// boltUpdateVersions calls update for every version of a document and saves the versions it returns true for.
+func boltUpdateVersions(bucket *bbolt.Bucket, update func(doc *Document) bool) error {
+	var docs []Document
+	if err := bucket.ForEach(func(_ []byte, v []byte) error {
+		var doc Document
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return err
+		}
+		if update(&doc) {
+			docs = append(docs, doc)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// modifying a bucket while iterating over it is not allowed
+	for _, doc := range docs {
+		if err := boltPutDocument(bucket, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltDeleteVersion deletes a version and releases its content.
+func boltDeleteVersion(tx *bbolt.Tx, bucket *bbolt.Bucket, key []byte) error {
+	var doc Document
+	if err := json.Unmarshal(bucket.Get(key), &doc); err != nil {
+		return err
+	}
+	if err := boltReleaseContent(tx, doc.ContentHash); err != nil {
+		return err
+	}
+	return bucket.Delete(key)
+}
+
+// boltDeleteDocument deletes a document with all its versions and releases their content.
+func boltDeleteDocument(tx *bbolt.Tx, documentID string) error {
+	if err := boltDocument(tx, documentID).ForEach(func(_ []byte, v []byte) error {
+		var doc Document
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return err
+		}
+		return boltReleaseContent(tx, doc.ContentHash)
+	}); err != nil {
+		return err
+	}
+	return tx.Bucket(boltDocumentsBucket).DeleteBucket([]byte(documentID))
+}
+
+func boltGetContent(tx *bbolt.Tx, hash string) (boltContent, error) {
+	v := tx.Bucket(boltContentsBucket).Get([]byte(hash))
+	if v == nil {
+		return boltContent{}, errors.New("document content not found: " + hash)
+	}
+	var content boltContent
+	err := json.Unmarshal(v, &content)
+	return content, err
+}
+
+// boltAddContent stores the content if it doesn't exist yet and counts the new version referencing it.
+func boltAddContent(tx *bbolt.Tx, hash string, content string) error {
+	c := boltContent{Content: content}
+	if v := tx.Bucket(boltContentsBucket).Get([]byte(hash)); v != nil {
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+	}
+	c.Refs++
+	return boltPutContent(tx, hash, c)
+}
+
+// boltReleaseContent removes a reference of a deleted version from the content and deletes it once it's no longer referenced.
+func boltReleaseContent(tx *bbolt.Tx, hash string) error {
+	content, err := boltGetContent(tx, hash)
+	if err != nil {
+		return err
+	}
+	content.Refs--
+	if content.Refs <= 0 {
+		return tx.Bucket(boltContentsBucket).Delete([]byte(hash))
+	}
+	return boltPutContent(tx, hash, content)
+}
+
+func boltPutContent(tx *bbolt.Tx, hash string, content boltContent) error {
+	v, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltContentsBucket).Put([]byte(hash), v)
+}
+
+func boltPutRevocation(bucket *bbolt.Bucket, tokenID string, revocation boltRevocation) error {
+	v, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(tokenID), v)
+}
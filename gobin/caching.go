@@ -0,0 +1,106 @@
+package gobin
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pinnedMaxAge is how long caches may keep a version pinned raw document without revalidating it.
+// It bounds how long a version stays cached after the document was made private, password protected or deleted.
+const pinnedMaxAge = time.Hour
+
+// documentETag returns the ETag of a document version rendered with the render options.
+// Without render options it's the VersionETag, which is also used by If-Match.
+// Rendered responses add a hash of the document ID, version and render options, so every rendering has its own ETag.
+func documentETag(document Document, renderOptions ...string) string {
+	etag := VersionETag(document.ID, document.Version)
+	if len(renderOptions) == 0 {
+		return etag
+	}
+	hash := sha256.New()
+	_, _ = hash.Write([]byte(document.ID + "\x00" + strconv.FormatInt(document.Version, 10)))
+	for _, option := range renderOptions {
+		_, _ = hash.Write([]byte("\x00" + option))
+	}
+	return fmt.Sprintf(`%s-%x"`, strings.TrimSuffix(etag, `"`), hash.Sum(nil)[:8])
+}
+
+// cacheDocument sets the ETag, Last-Modified and Cache-Control headers of a document response.
+// pinned is true for responses which only contain the content of a version requested by its number, see cacheControl.
+// If the copy of the client is still valid it responds with 304 Not Modified and returns true.
+// View limited documents are never cached or answered with 304, as every view has to be counted.
+func (s *Server) cacheDocument(w http.ResponseWriter, r *http.Request, document Document, pinned bool, renderOptions ...string) bool {
+	etag := documentETag(document, renderOptions...)
+	lastModified := time.Unix(document.CreatedAt, 0).UTC()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	if len(renderOptions) > 0 {
+		// the style of rendered documents defaults to the style cookie
+		w.Header().Add("Vary", "Cookie")
+	}
+	if document.ViewsLeft != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		return false
+	}
+	w.Header().Set("Cache-Control", cacheControl(document, pinned, time.Now()))
+
+	if !notModified(r, etag, lastModified) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// cacheRendered stops caches from storing a document which was shown as plaintext because rendering it timed out, it may be highlighted on the next request.
+// It has to be called before the status is written.
+func cacheRendered(w http.ResponseWriter, rendered renderedDocument) {
+	if errors.Is(rendered.Unhighlighted, ErrRenderTimeout) {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+}
+
+// cacheControl returns the Cache-Control header of a document version.
+// The content of a pinned version never changes, so pinned versions of documents anyone can read may be cached without revalidation until the document expires, at most for pinnedMaxAge.
+// Everything else has to be revalidated on every request, as the visibility and password of a document apply to all its versions and can change at any time.
+// Documents which need a token or password may only be cached by the client.
+func cacheControl(document Document, pinned bool, now time.Time) string {
+	if document.Private || document.PasswordHash != nil {
+		return "private, no-cache"
+	}
+	if pinned && document.ViewsLeft == nil {
+		maxAge := pinnedMaxAge
+		if document.ExpiresAt != nil {
+			if untilExpiry := time.Unix(*document.ExpiresAt, 0).Sub(now); untilExpiry < maxAge {
+				maxAge = untilExpiry
+			}
+		}
+		if maxAge >= time.Second {
+			return fmt.Sprintf("public, max-age=%d, immutable", int64(maxAge/time.Second))
+		}
+	}
+	return "public, no-cache"
+}
+
+// notModified checks if a GET or HEAD request has a valid copy of the response by If-None-Match, or by If-Modified-Since if If-None-Match is missing.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := r.Header.Values("If-None-Match"); len(ifNoneMatch) > 0 {
+		for _, match := range strings.Split(strings.Join(ifNoneMatch, ","), ",") {
+			match = strings.TrimSpace(match)
+			// If-None-Match uses the weak comparison, proxies may weaken our ETags when they compress responses
+			if match == "*" || strings.TrimPrefix(match, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.After(ifModifiedSince)
+}
@@ -0,0 +1,86 @@
+package gobin
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDocumentETag(t *testing.T) {
+	document := Document{ID: "hocwr6i6", Version: 2}
+	for _, etag := range []string{
+		documentETag(document),
+		documentETag(document, "html", "monokai"),
+		"W/" + documentETag(document),
+	} {
+		documentID, version, ok := ParseVersionETag(etag)
+		if !ok || documentID != document.ID || version != document.Version {
+			t.Errorf("expected ETag %s to be of version %d of %s, got %d of %q", etag, document.Version, document.ID, version, documentID)
+		}
+	}
+
+	// the same version of another document never has the same ETag
+	other := Document{ID: "abc12345", Version: 2}
+	if documentETag(document) == documentETag(other) || documentETag(document, "html") == documentETag(other, "html") {
+		t.Error("expected different ETags for the same version of different documents")
+	}
+	if documentETag(document, "html") == documentETag(document, "svg") {
+		t.Error("expected different ETags for different render options")
+	}
+
+	for _, etag := range []string{"", `"2"`, `"hocwr6i6"`, `"hocwr6i6-x"`, `hocwr6i6-2`} {
+		if _, _, ok := ParseVersionETag(etag); ok {
+			t.Errorf("expected ETag %s to be invalid", etag)
+		}
+	}
+}
+
+func TestParseIfMatch(t *testing.T) {
+	for _, c := range []struct {
+		ifMatch string
+		want    int64
+		ok      bool
+	}{
+		{"", 0, true},
+		{"*", 0, true},
+		{`"hocwr6i6-2"`, 2, true},
+		{`"hocwr6i6-1", "hocwr6i6-2"`, 2, true},
+		{`"hocwr6i6-1"`, 0, false},
+		{`"abc12345-2"`, 0, false},
+		{`"2"`, 0, false},
+		{`W/"hocwr6i6-2"`, 0, false},
+	} {
+		r := httptest.NewRequest("PATCH", "/documents/hocwr6i6", nil)
+		if c.ifMatch != "" {
+			r.Header.Set(IfMatchHeader, c.ifMatch)
+		}
+		version, ok := parseIfMatch(r, "hocwr6i6", 2)
+		if version != c.want || ok != c.ok {
+			t.Errorf("If-Match %s: expected %d %t, got %d %t", c.ifMatch, c.want, c.ok, version, ok)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	password := "hash"
+	viewsLeft := int64(1)
+	expiresAt := now.Add(10 * time.Minute).Unix()
+	for _, c := range []struct {
+		name     string
+		document Document
+		pinned   bool
+		want     string
+	}{
+		{"latest", Document{}, false, "public, no-cache"},
+		{"pinned", Document{}, true, "public, max-age=3600, immutable"},
+		{"pinned expiring", Document{ExpiresAt: &expiresAt}, true, "public, max-age=600, immutable"},
+		{"pinned private", Document{Private: true}, true, "private, no-cache"},
+		{"pinned password", Document{PasswordHash: &password}, true, "private, no-cache"},
+		{"pinned view limited", Document{ViewsLeft: &viewsLeft}, true, "public, no-cache"},
+	} {
+		if got := cacheControl(c.document, c.pinned, now); got != c.want {
+			t.Errorf("%s: expected %q, got %q", c.name, c.want, got)
+		}
+	}
+}
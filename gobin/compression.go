@@ -0,0 +1,94 @@
+package gobin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrInvalidCompression         = errors.New("invalid compression, must be one of: gzip, zstd")
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding, must be one of: gzip, zstd")
+)
+
+var (
+	// the zstd encoder & decoder are safe for concurrent use of EncodeAll & DecodeAll
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// validateCompression checks if the compression is supported, an empty compression stores contents uncompressed.
+func validateCompression(compression string) error {
+	switch compression {
+	case "", "gzip", "zstd":
+		return nil
+	default:
+		return ErrInvalidCompression
+	}
+}
+
+// compress compresses the content with the compression, which is stored next to the compressed content.
+func compress(compression string, content string) ([]byte, error) {
+	switch compression {
+	case "gzip":
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(w, content); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		return zstdEncoder.EncodeAll([]byte(content), nil), nil
+	default:
+		return nil, ErrInvalidCompression
+	}
+}
+
+// decompress decompresses data compressed by compress with the same compression.
+func decompress(compression string, data []byte) (string, error) {
+	switch compression {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return string(content), nil
+	case "zstd":
+		content, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return "", err
+		}
+		return string(content), nil
+	default:
+		return "", ErrInvalidCompression
+	}
+}
+
+// decompressReader returns a reader which decompresses the request body with the Content-Encoding, an empty or identity encoding isn't compressed.
+func decompressReader(contentEncoding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return io.NopCloser(r), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+}
@@ -2,22 +2,29 @@ package gobin
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	DevMode         bool             `cfg:"dev_mode"`
-	Debug           bool             `cfg:"debug"`
-	ListenAddr      string           `cfg:"listen_addr"`
-	Database        DatabaseConfig   `cfg:"database"`
-	MaxDocumentSize int              `cfg:"max_document_size"`
-	RateLimit       *RateLimitConfig `cfg:"rate_limit"`
-	JWTSecret       string           `cfg:"jwt_secret"`
+	DevMode         bool              `cfg:"dev_mode"`
+	Debug           bool              `cfg:"debug"`
+	ListenAddr      string            `cfg:"listen_addr"`
+	ShutdownTimeout time.Duration     `cfg:"shutdown_timeout"`
+	Database        DatabaseConfig    `cfg:"database"`
+	MaxDocumentSize int               `cfg:"max_document_size"`
+	MaxBodySize     int64             `cfg:"max_body_size"`
+	RawContentTypes bool              `cfg:"raw_content_types"`
+	RateLimit       *RateLimitConfig  `cfg:"rate_limit"`
+	JWTSecret       string            `cfg:"jwt_secret"`
+	Metrics         MetricsConfig     `cfg:"metrics"`
+	Render          RenderConfig      `cfg:"render"`
+	RenderCache     RenderCacheConfig `cfg:"render_cache"`
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("\n DevMode: %t\n Debug: %t\n ListenAddr: %s\n Database: %s\n MaxDocumentSize: %d\n RateLimit: %s\n JWTSecret: %s\n", c.DevMode, c.Debug, c.ListenAddr, c.Database, c.MaxDocumentSize, c.RateLimit, strings.Repeat("*", len(c.JWTSecret)))
+	return fmt.Sprintf("\n DevMode: %t\n Debug: %t\n ListenAddr: %s\n ShutdownTimeout: %s\n Database: %s\n MaxDocumentSize: %d\n MaxBodySize: %d\n RawContentTypes: %t\n RateLimit: %s\n JWTSecret: %s\n Metrics: %s\n Render: %s\n RenderCache: %s\n", c.DevMode, c.Debug, c.ListenAddr, c.ShutdownTimeout, c.Database, c.MaxDocumentSize, c.MaxBodySize, c.RawContentTypes, c.RateLimit, strings.Repeat("*", len(c.JWTSecret)), c.Metrics, c.Render, c.RenderCache)
 }
 
 type DatabaseConfig struct {
@@ -25,8 +32,10 @@ type DatabaseConfig struct {
 	Debug           bool          `cfg:"debug"`
 	ExpireAfter     time.Duration `cfg:"expire_after"`
 	CleanupInterval time.Duration `cfg:"cleanup_interval"`
+	// SkipMigrations stops postgres & sqlite from applying migrations on startup, gobin fails to start if migrations are pending then
+	SkipMigrations bool `cfg:"skip_migrations"`
 
-	// SQLite
+	// SQLite & bbolt
 	Path string `cfg:"path"`
 
 	// PostgreSQL
@@ -36,18 +45,38 @@ type DatabaseConfig struct {
 	Password string `cfg:"password"`
 	Database string `cfg:"database"`
 	SSLMode  string `cfg:"ssl_mode"`
+
+	// Compression is either "gzip" or "zstd", postgres & sqlite store content uncompressed if it's empty
+	Compression string `cfg:"compression"`
+
+	// Encryption encrypts the content of postgres & sqlite documents
+	Encryption EncryptionConfig `cfg:"encryption"`
+
+	// ContentStorage stores the content of postgres & sqlite documents outside the database
+	ContentStorage ContentStorageConfig `cfg:"content_storage"`
 }
 
 func (c DatabaseConfig) String() string {
-	str := fmt.Sprintf("\n  Type: %s\n  Debug: %t\n  ExpireAfter: %s\n  CleanupInterval: %s\n  ", c.Type, c.Debug, c.ExpireAfter, c.CleanupInterval)
+	str := fmt.Sprintf("\n  Type: %s\n  Debug: %t\n  ExpireAfter: %s\n  CleanupInterval: %s\n  SkipMigrations: %t\n  ", c.Type, c.Debug, c.ExpireAfter, c.CleanupInterval, c.SkipMigrations)
 	switch c.Type {
 	case "postgres":
 		str += fmt.Sprintf("Host: %s\n  Port: %d\n  Username: %s\n  Password: %s\n  Database: %s\n  SSLMode: %s", c.Host, c.Port, c.Username, strings.Repeat("*", len(c.Password)), c.Database, c.SSLMode)
-	case "sqlite":
+	case "sqlite", "bbolt":
 		str += fmt.Sprintf("Path: %s", c.Path)
+	case "memory":
+		str += "Documents are only kept in memory!"
 	default:
 		str += "Invalid database type!"
 	}
+	if c.Compression != "" {
+		str += fmt.Sprintf("\n  Compression: %s", c.Compression)
+	}
+	if c.Encryption.Enabled() {
+		str += fmt.Sprintf("\n  Encryption: %s", c.Encryption)
+	}
+	if c.ContentStorage.Type != "" {
+		str += fmt.Sprintf("\n  ContentStorage: %s", c.ContentStorage)
+	}
 	return str
 }
 
@@ -65,3 +94,103 @@ type RateLimitConfig struct {
 func (c RateLimitConfig) String() string {
 	return fmt.Sprintf("\n  Requests: %d\n  Duration: %s\n  Whitelist: %v\n  Blacklist: %v", c.Requests, c.Duration, c.Whitelist, c.Blacklist)
 }
+
+type MetricsConfig struct {
+	Enabled    bool   `cfg:"enabled"`
+	ListenAddr string `cfg:"listen_addr"`
+}
+
+func (c MetricsConfig) String() string {
+	return fmt.Sprintf("\n  Enabled: %t\n  ListenAddr: %s", c.Enabled, c.ListenAddr)
+}
+
+type RenderConfig struct {
+	// Workers is the number of documents rendered at the same time, it defaults to the number of CPUs
+	Workers int `cfg:"workers"`
+	// Timeout is how long a document may take to render, including waiting for a worker, 0 disables it
+	Timeout time.Duration `cfg:"timeout"`
+	// MaxSize is the size in bytes above which documents are shown as plaintext instead of rendering them, 0 disables it
+	MaxSize int `cfg:"max_size"`
+}
+
+func (c RenderConfig) String() string {
+	return fmt.Sprintf("\n  Workers: %d\n  Timeout: %s\n  MaxSize: %d", c.Workers, c.Timeout, c.MaxSize)
+}
+
+type RenderCacheConfig struct {
+	// MaxSize is the memory budget of rendered documents cached in-process in bytes, 0 disables the in-process cache
+	MaxSize int64 `cfg:"max_size"`
+	// Type is "redis" to share rendered documents between gobin instances, they are only cached in-process if it's empty
+	Type string `cfg:"type"`
+	// TTL is how long the external cache keeps rendered documents, it defaults to 24h
+	TTL time.Duration `cfg:"ttl"`
+
+	// Redis
+	Address  string `cfg:"address"`
+	Password string `cfg:"password"`
+	DB       int    `cfg:"db"`
+}
+
+func (c RenderCacheConfig) String() string {
+	str := fmt.Sprintf("\n  MaxSize: %d\n  Type: %s", c.MaxSize, c.Type)
+	switch c.Type {
+	case "redis":
+		str += fmt.Sprintf("\n  TTL: %s\n  Address: %s\n  Password: %s\n  DB: %d", c.TTL, c.Address, strings.Repeat("*", len(c.Password)), c.DB)
+	}
+	return str
+}
+
+type EncryptionConfig struct {
+	// KeyID is the id of the key new content is encrypted with, content is stored unencrypted if it's empty
+	KeyID string `cfg:"key_id"`
+	// Keys are the base64 encoded 32 byte AES keys by their id, keys are required as long as content is encrypted with them
+	Keys map[string]string `cfg:"keys"`
+	// KeyFile is a JSON file with more keys in the same format as Keys
+	KeyFile string `cfg:"key_file"`
+	// ReencryptInterval is the interval in which content encrypted with another key than KeyID is re-encrypted, it defaults to 1h
+	ReencryptInterval time.Duration `cfg:"reencrypt_interval"`
+}
+
+// Enabled returns true if any keys are configured, which doesn't mean new content is encrypted.
+func (c EncryptionConfig) Enabled() bool {
+	return c.KeyID != "" || len(c.Keys) > 0 || c.KeyFile != ""
+}
+
+func (c EncryptionConfig) String() string {
+	keyIDs := make([]string, 0, len(c.Keys))
+	for id := range c.Keys {
+		keyIDs = append(keyIDs, id)
+	}
+	sort.Strings(keyIDs)
+	return fmt.Sprintf("\n   KeyID: %s\n   Keys: %v\n   KeyFile: %s\n   ReencryptInterval: %s", c.KeyID, keyIDs, c.KeyFile, c.ReencryptInterval)
+}
+
+type ContentStorageConfig struct {
+	// Type is either "dir" or "s3", content is stored in the database if it's empty
+	Type string `cfg:"type"`
+
+	// Directory
+	Path string `cfg:"path"`
+
+	// S3
+	Endpoint        string `cfg:"endpoint"`
+	Bucket          string `cfg:"bucket"`
+	Region          string `cfg:"region"`
+	AccessKeyID     string `cfg:"access_key_id"`
+	SecretAccessKey string `cfg:"secret_access_key"`
+	UseSSL          bool   `cfg:"use_ssl"`
+	PathStyle       bool   `cfg:"path_style"`
+}
+
+func (c ContentStorageConfig) String() string {
+	str := fmt.Sprintf("\n   Type: %s\n   ", c.Type)
+	switch c.Type {
+	case "dir":
+		str += fmt.Sprintf("Path: %s", c.Path)
+	case "s3":
+		str += fmt.Sprintf("Endpoint: %s\n   Bucket: %s\n   Region: %s\n   AccessKeyID: %s\n   SecretAccessKey: %s\n   UseSSL: %t\n   PathStyle: %t", c.Endpoint, c.Bucket, c.Region, c.AccessKeyID, strings.Repeat("*", len(c.SecretAccessKey)), c.UseSSL, c.PathStyle)
+	default:
+		str += "Invalid content storage type!"
+	}
+	return str
+}
@@ -0,0 +1,664 @@
+package gobin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// deltaSnapshotInterval is the maximum length of a delta chain, at least every 10th version of a document is stored in full.
+	deltaSnapshotInterval = 10
+
+	// maxQueryValues limits the number of values passed to IN queries to stay below the parameter limit of the databases.
+	maxQueryValues = 500
+
+	// rewriteBatchSize is the number of contents rewritten in one transaction, small batches keep the write lock of sqlite short.
+	rewriteBatchSize = 50
+
+	// contentChainQuery selects a content and all contents it's a delta of, ordered from the content to the content stored in full.
+	contentChainQuery = `WITH RECURSIVE chain (hash, content, compressed_content, compression, encrypted_content, encryption_key_id, content_key, base_hash, depth) AS (
+    SELECT hash, content, compressed_content, compression, encrypted_content, encryption_key_id, content_key, base_hash, 0 FROM document_contents WHERE hash = $1
+    UNION ALL
+    SELECT c.hash, c.content, c.compressed_content, c.compression, c.encrypted_content, c.encryption_key_id, c.content_key, c.base_hash, chain.depth + 1 FROM document_contents c JOIN chain ON c.hash = chain.base_hash
+)
+SELECT hash, content, compressed_content, compression, encrypted_content, encryption_key_id, content_key, base_hash FROM chain ORDER BY depth`
+
+	// contentDependentsQuery selects the length of the longest delta chain ending at a content.
+	contentDependentsQuery = `WITH RECURSIVE dependents (hash, depth) AS (
+    SELECT hash, 1 FROM document_contents WHERE base_hash = $1
+    UNION ALL
+    SELECT c.hash, dependents.depth + 1 FROM document_contents c JOIN dependents ON c.base_hash = dependents.hash
+)
+SELECT COALESCE(MAX(depth), 0) FROM dependents`
+
+	// contentColumns selects all columns of document_contents.
+	contentColumns = "hash, content, compressed_content, compression, encrypted_content, encryption_key_id, content_key, base_hash"
+)
+
+// documentContent is a row of document_contents.
+// The content is stored as delta of the base content if BaseHash is set, compressed in the compressed_content column if Compression is set,
+// encrypted in the encrypted_content column if EncryptionKeyID is set and in the content storage instead of the content columns if ContentKey is set.
+// Encrypted contents are compressed before they are encrypted.
+type documentContent struct {
+	Hash              string  `db:"hash"`
+	Content           string  `db:"content"`
+	CompressedContent []byte  `db:"compressed_content"`
+	Compression       *string `db:"compression"`
+	EncryptedContent  []byte  `db:"encrypted_content"`
+	EncryptionKeyID   *string `db:"encryption_key_id"`
+	ContentKey        *string `db:"content_key"`
+	BaseHash          *string `db:"base_hash"`
+}
+
+// contentTx is a transaction which keeps track of the blobs it put into and removed from the content storage.
+// Put blobs are deleted if the transaction is rolled back, removed blobs once it's committed.
+type contentTx struct {
+	*sqlx.Tx
+	put     []*string
+	removed []*string
+}
+
+func (d *DB) beginContentTx(ctx context.Context) (*contentTx, error) {
+	tx, err := d.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &contentTx{Tx: tx}, nil
+}
+
+func (d *DB) commit(tx *contentTx) error {
+	if err := tx.Commit(); err != nil {
+		d.deleteContent(tx.put...)
+		return err
+	}
+	d.deleteContent(tx.removed...)
+	return nil
+}
+
+// rollback rolls back the transaction if it's still open, it's safe to defer it.
+func (d *DB) rollback(tx *contentTx) {
+	if err := tx.Rollback(); errors.Is(err, sql.ErrTxDone) {
+		return
+	}
+	d.deleteContent(tx.put...)
+}
+
+// storeContent stores the content once per hash and returns its hash.
+// The content becomes the latest version of a document, so it's stored in full even if it exists as delta.
+func (d *DB) storeContent(ctx context.Context, tx *contentTx, content string) (string, error) {
+	hash := contentHash(content)
+
+	// the no-op update checks if the content exists and locks it, so it can't be garbage collected or stored as delta before the new version references it
+	var existing []documentContent
+	if err := tx.SelectContext(ctx, &existing, "UPDATE document_contents SET hash = hash WHERE hash = $1 RETURNING hash, content_key, base_hash", hash); err != nil {
+		return "", err
+	}
+	if len(existing) > 0 && existing[0].BaseHash == nil {
+		return hash, nil
+	}
+
+	row, err := d.writeContent(ctx, tx, hash, content, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(existing) > 0 {
+		tx.removed = append(tx.removed, existing[0].ContentKey)
+		return hash, updateContent(ctx, tx, row)
+	}
+
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO document_contents ("+contentColumns+") VALUES (:hash, :content, :compressed_content, :compression, :encrypted_content, :encryption_key_id, :content_key, :base_hash) ON CONFLICT (hash) DO NOTHING", row)
+	if err != nil {
+		return "", err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		// the content was stored concurrently, the version references that one
+		tx.removed = append(tx.removed, row.ContentKey)
+	}
+	return hash, nil
+}
+
+// compressContent stores the content of hash as delta of the content of baseHash, base has to be the content of baseHash.
+// It returns true if the content is stored as delta now, see storeDelta.
+func (d *DB) compressContent(ctx context.Context, tx *contentTx, hash string, baseHash string, base string) (bool, error) {
+	current, ok, err := d.deltaCandidate(ctx, tx, hash, baseHash)
+	if err != nil || !ok {
+		return false, err
+	}
+	content, err := d.readContent(ctx, current)
+	if err != nil || !canEncodeDelta(base, content) {
+		return false, err
+	}
+	return d.storeDelta(ctx, tx, current, baseHash, content, encodeDelta(base, content))
+}
+
+// storeDelta stores the current content as the delta of the content of baseHash, which has been encoded from the content beforehand.
+// The current content has to be a delta candidate, it's kept in full if the delta isn't much smaller than the content.
+// It returns true if the content is stored as delta now.
+func (d *DB) storeDelta(ctx context.Context, tx *contentTx, current documentContent, baseHash string, content string, delta string) (bool, error) {
+	if len(delta) > len(content)/2 {
+		return false, nil
+	}
+
+	row, err := d.writeContent(ctx, tx, current.Hash, delta, &baseHash)
+	if err != nil {
+		return false, err
+	}
+	tx.removed = append(tx.removed, current.ContentKey)
+	if err = updateContent(ctx, tx, row); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deltaCandidate returns the content of hash if it can be stored as delta of the content of baseHash.
+// Contents are kept in full if they are the latest version of a document, their base is a delta or the delta chain would get too long.
+func (d *DB) deltaCandidate(ctx context.Context, tx *contentTx, hash string, baseHash string) (documentContent, bool, error) {
+	if hash == baseHash {
+		return documentContent{}, false, nil
+	}
+	var current documentContent
+	if err := tx.GetContext(ctx, &current, "SELECT "+contentColumns+" FROM document_contents WHERE hash = $1", hash); err != nil {
+		return documentContent{}, false, err
+	}
+	if current.BaseHash != nil {
+		return documentContent{}, false, nil
+	}
+
+	// the latest versions are always stored in full, so reading them and searching doesn't need to reconstruct them
+	var latest bool
+	if err := tx.GetContext(ctx, &latest, "SELECT EXISTS(SELECT 1 FROM documents d WHERE d.content_hash = $1 AND d.version = (SELECT MAX(version) FROM documents WHERE id = d.id))", hash); err != nil {
+		return documentContent{}, false, err
+	}
+	if latest {
+		return documentContent{}, false, nil
+	}
+	var baseFull bool
+	if err := tx.GetContext(ctx, &baseFull, "SELECT EXISTS(SELECT 1 FROM document_contents WHERE hash = $1 AND base_hash IS NULL)", baseHash); err != nil {
+		return documentContent{}, false, err
+	}
+	if !baseFull {
+		return documentContent{}, false, nil
+	}
+	var depth int
+	if err := tx.GetContext(ctx, &depth, contentDependentsQuery, hash); err != nil {
+		return documentContent{}, false, err
+	}
+	if depth+1 >= deltaSnapshotInterval {
+		return documentContent{}, false, nil
+	}
+	return current, true, nil
+}
+
+// CompressVersions stores the versions of all existing documents as deltas, like UpdateDocument does for new versions, and returns the number of compressed contents.
+// Every document is compressed in its own transaction, so it can be stopped and run again at any time.
+func (d *DB) CompressVersions(ctx context.Context) (int64, error) {
+	defer d.metrics.ObserveQuery("compress_versions", time.Now())
+	var documentIDs []string
+	if err := d.dbx.SelectContext(ctx, &documentIDs, "SELECT DISTINCT id FROM documents"); err != nil {
+		return 0, err
+	}
+
+	var compressed int64
+	for _, documentID := range documentIDs {
+		n, err := d.compressDocumentVersions(ctx, documentID)
+		if err != nil {
+			return compressed, err
+		}
+		compressed += n
+	}
+	return compressed, nil
+}
+
+func (d *DB) compressDocumentVersions(ctx context.Context, documentID string) (int64, error) {
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.rollback(tx)
+
+	var hashes []string
+	if err = tx.SelectContext(ctx, &hashes, "SELECT content_hash FROM documents WHERE id = $1 ORDER BY version", documentID); err != nil {
+		return 0, err
+	}
+
+	// versions are compressed from oldest to newest like they were created, which starts a new delta chain every deltaSnapshotInterval versions
+	var compressed int64
+	for i := 0; i+1 < len(hashes); i++ {
+		base, err := d.getContent(ctx, tx, hashes[i+1], nil)
+		if err != nil {
+			return 0, err
+		}
+		ok, err := d.compressContent(ctx, tx, hashes[i], hashes[i+1], base)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			compressed++
+		}
+	}
+	return compressed, d.commit(tx)
+}
+
+// RecompressContents compresses all existing contents with the configured compression, or stores them uncompressed if none is configured, and returns the number of recompressed contents.
+// Contents are recompressed in batches with their own transaction, so it can be stopped and run again at any time.
+func (d *DB) RecompressContents(ctx context.Context) (int64, error) {
+	defer d.metrics.ObserveQuery("recompress_contents", time.Now())
+	return d.rewriteContents(ctx, "COALESCE(compression, '') <> $3", d.compression, func(content documentContent) bool {
+		return stringValue(content.Compression) != d.compression
+	})
+}
+
+// ReencryptContents encrypts all existing contents which aren't encrypted with the configured key with it, or decrypts them if no key is configured, and returns the number of re-encrypted contents.
+// Contents are re-encrypted in batches with their own transaction, so it can be stopped and run again at any time.
+func (d *DB) ReencryptContents(ctx context.Context) (int64, error) {
+	defer d.metrics.ObserveQuery("reencrypt_contents", time.Now())
+	return d.rewriteContents(ctx, "COALESCE(encryption_key_id, '') <> $3", d.encryptionKeyID(), func(content documentContent) bool {
+		return stringValue(content.EncryptionKeyID) != d.encryptionKeyID()
+	})
+}
+
+// rewriteContents writes all contents which need a rewrite again with the current compression & encryption settings and returns the number of rewritten contents.
+// The condition selects the contents which may need a rewrite with value as $3, needsRewrite checks them again once they are locked.
+func (d *DB) rewriteContents(ctx context.Context, condition string, value string, needsRewrite func(content documentContent) bool) (int64, error) {
+	var (
+		rewritten int64
+		lastHash  string
+	)
+	for {
+		var hashes []string
+		if err := d.dbx.SelectContext(ctx, &hashes, "SELECT hash FROM document_contents WHERE hash > $1 AND "+condition+" ORDER BY hash LIMIT $2", lastHash, rewriteBatchSize, value); err != nil {
+			return rewritten, err
+		}
+		if len(hashes) == 0 {
+			return rewritten, nil
+		}
+		n, err := d.rewriteContentBatch(ctx, hashes, needsRewrite)
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten += n
+		lastHash = hashes[len(hashes)-1]
+	}
+}
+
+func (d *DB) rewriteContentBatch(ctx context.Context, hashes []string, needsRewrite func(content documentContent) bool) (int64, error) {
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.rollback(tx)
+
+	var rewritten int64
+	for _, hash := range hashes {
+		// the no-op update locks the content, it may have been garbage collected since it was listed
+		var current []documentContent
+		if err = tx.SelectContext(ctx, &current, "UPDATE document_contents SET hash = hash WHERE hash = $1 RETURNING "+contentColumns, hash); err != nil {
+			return 0, err
+		}
+		if len(current) == 0 || !needsRewrite(current[0]) {
+			continue
+		}
+
+		raw, err := d.readContent(ctx, current[0])
+		if err != nil {
+			return 0, err
+		}
+		row, err := d.writeContent(ctx, tx, hash, raw, current[0].BaseHash)
+		if err != nil {
+			return 0, err
+		}
+		if needsRewrite(row) {
+			// the content is stored the same way again, like contents which don't get smaller by compressing them
+			tx.removed = append(tx.removed, row.ContentKey)
+			continue
+		}
+		tx.removed = append(tx.removed, current[0].ContentKey)
+		if err = updateContent(ctx, tx, row); err != nil {
+			return 0, err
+		}
+		if row.BaseHash == nil {
+			// contents are only indexed while they aren't encrypted at rest
+			if err = d.indexContentDocuments(ctx, tx, hash, raw); err != nil {
+				return 0, err
+			}
+		}
+		rewritten++
+	}
+	return rewritten, d.commit(tx)
+}
+
+// loadContent reads the content of the document version, which may be stored as delta or in the content storage.
+// Reconstructed contents are cached in contents if it's not nil.
+func (d *DB) loadContent(ctx context.Context, q sqlx.QueryerContext, doc *Document, contents map[string]string) error {
+	content, err := d.readContent(ctx, documentContent{
+		Hash:              doc.ContentHash,
+		Content:           doc.Content,
+		CompressedContent: doc.CompressedContent,
+		Compression:       doc.Compression,
+		EncryptedContent:  doc.EncryptedContent,
+		EncryptionKeyID:   doc.EncryptionKeyID,
+		ContentKey:        doc.ContentKey,
+	})
+	if err != nil {
+		return err
+	}
+	if doc.BaseHash != nil {
+		base, err := d.getContent(ctx, q, *doc.BaseHash, contents)
+		if err != nil {
+			return err
+		}
+		if content, err = applyDelta(base, content); err != nil {
+			return err
+		}
+	}
+	doc.Content = content
+	doc.CompressedContent = nil
+	doc.EncryptedContent = nil
+	if contents != nil {
+		contents[doc.ContentHash] = content
+	}
+	return nil
+}
+
+// getContent reads the content of the hash and reconstructs it from its delta chain.
+// Reconstructed contents are cached in contents if it's not nil.
+func (d *DB) getContent(ctx context.Context, q sqlx.QueryerContext, hash string, contents map[string]string) (string, error) {
+	if content, ok := contents[hash]; ok {
+		return content, nil
+	}
+	var chain []documentContent
+	if err := sqlx.SelectContext(ctx, q, &chain, contentChainQuery, hash); err != nil {
+		return "", err
+	}
+	if len(chain) == 0 {
+		return "", errors.New("document content not found: " + hash)
+	}
+
+	// the last content of the chain is stored in full, every other content is a delta of the next one
+	var content string
+	for i := len(chain) - 1; i >= 0; i-- {
+		raw, err := d.readContent(ctx, chain[i])
+		if err != nil {
+			return "", err
+		}
+		if chain[i].BaseHash == nil {
+			content = raw
+		} else if content, err = applyDelta(content, raw); err != nil {
+			return "", err
+		}
+		if contents != nil {
+			contents[chain[i].Hash] = content
+		}
+	}
+	return content, nil
+}
+
+// writeContent compresses the raw content with the configured compression, encrypts it with the configured key and puts it into the content storage, if one is configured.
+// The raw content is a delta of the content of baseHash if it isn't nil. It returns the row of the content.
+func (d *DB) writeContent(ctx context.Context, tx *contentTx, hash string, raw string, baseHash *string) (documentContent, error) {
+	row := documentContent{
+		Hash:     hash,
+		Content:  raw,
+		BaseHash: baseHash,
+	}
+	data := []byte(raw)
+	if d.compression != "" {
+		compressed, err := compress(d.compression, raw)
+		if err != nil {
+			return documentContent{}, err
+		}
+		// contents which don't get smaller, like very short ones, are stored uncompressed
+		if len(compressed) < len(raw) {
+			compression := d.compression
+			data = compressed
+			row.Content = ""
+			row.CompressedContent = compressed
+			row.Compression = &compression
+		}
+	}
+	if d.encryptionKeyID() != "" {
+		keyID, encrypted, err := d.keyring.encrypt(hash, data)
+		if err != nil {
+			return documentContent{}, err
+		}
+		data = encrypted
+		row.Content = ""
+		row.CompressedContent = nil
+		row.EncryptedContent = encrypted
+		row.EncryptionKeyID = &keyID
+	}
+	if d.blobs == nil {
+		return row, nil
+	}
+
+	// keys are random instead of the hash, so deleting the blob of garbage collected content can't race with putting it again
+	key := hash + "/" + randomString(16)
+	if err := d.blobs.Put(ctx, key, string(data)); err != nil {
+		return documentContent{}, err
+	}
+	tx.put = append(tx.put, &key)
+	row.Content = ""
+	row.CompressedContent = nil
+	row.EncryptedContent = nil
+	row.ContentKey = &key
+	return row, nil
+}
+
+// updateContent replaces the stored content of the row, the content storage blob it replaces has to be removed by the caller.
+func updateContent(ctx context.Context, tx *contentTx, row documentContent) error {
+	_, err := tx.NamedExecContext(ctx, "UPDATE document_contents SET content = :content, compressed_content = :compressed_content, compression = :compression, encrypted_content = :encrypted_content, encryption_key_id = :encryption_key_id, content_key = :content_key, base_hash = :base_hash WHERE hash = :hash", row)
+	return err
+}
+
+// readContent returns the raw content of the row, which is either a full content or a delta.
+func (d *DB) readContent(ctx context.Context, content documentContent) (string, error) {
+	var data []byte
+	switch {
+	case content.ContentKey != nil:
+		if d.blobs == nil {
+			return "", errors.New("document content is stored in a content storage, but none is configured")
+		}
+		blob, err := d.blobs.Get(ctx, *content.ContentKey)
+		if err != nil {
+			return "", err
+		}
+		data = []byte(blob)
+	case content.EncryptionKeyID != nil:
+		data = content.EncryptedContent
+	case content.Compression != nil:
+		data = content.CompressedContent
+	default:
+		return content.Content, nil
+	}
+
+	if content.EncryptionKeyID != nil {
+		if d.keyring == nil {
+			return "", fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, *content.EncryptionKeyID)
+		}
+		var err error
+		if data, err = d.keyring.decrypt(*content.EncryptionKeyID, content.Hash, data); err != nil {
+			return "", err
+		}
+	}
+	if content.Compression != nil {
+		return decompress(*content.Compression, data)
+	}
+	return string(data), nil
+}
+
+// encryptionKeyID returns the id of the key new contents are encrypted with, it's empty if contents are stored unencrypted.
+func (d *DB) encryptionKeyID() string {
+	if d.keyring == nil {
+		return ""
+	}
+	return d.keyring.keyID
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+type deletedVersion struct {
+	ID          string `db:"id"`
+	ContentHash string `db:"content_hash"`
+}
+
+// deleteVersions executes the delete query, which has to return the id and content_hash of the deleted rows, and returns the number of deleted rows.
+// The new latest versions of the affected documents are stored in full again and contents which are no longer referenced are garbage collected.
+// The version sequences of documents without versions left are deleted with them.
+func (d *DB) deleteVersions(ctx context.Context, tx *contentTx, query string, args ...any) (int64, error) {
+	var deleted []deletedVersion
+	if err := tx.SelectContext(ctx, &deleted, query, args...); err != nil {
+		return 0, err
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	documentIDs := make([]string, 0, len(deleted))
+	hashes := make([]string, 0, len(deleted))
+	for _, version := range deleted {
+		documentIDs = append(documentIDs, version.ID)
+		hashes = append(hashes, version.ContentHash)
+	}
+
+	if err := deleteDocumentSequences(ctx, tx, documentIDs); err != nil {
+		return 0, err
+	}
+	bases, err := d.decompressLatestContents(ctx, tx, documentIDs)
+	if err != nil {
+		return 0, err
+	}
+	// the index needs the contents of the deleted versions to remove them, so it's updated before they are garbage collected
+	if _, err = d.indexDocuments(ctx, tx, documentIDs, nil); err != nil {
+		return 0, err
+	}
+	if err = d.deleteUnreferencedContents(ctx, tx, append(hashes, bases...)); err != nil {
+		return 0, err
+	}
+	return int64(len(deleted)), nil
+}
+
+// deleteDocumentSequences deletes the version sequences of the documents which have no versions left.
+func deleteDocumentSequences(ctx context.Context, tx *contentTx, documentIDs []string) error {
+	for _, chunk := range chunkValues(documentIDs) {
+		query, args, err := sqlx.In("DELETE FROM document_sequences WHERE id IN (?) AND NOT EXISTS (SELECT 1 FROM documents WHERE id = document_sequences.id)", chunk)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decompressLatestContents stores the contents of the latest versions of the documents in full, if they are stored as delta, and returns their former bases.
+func (d *DB) decompressLatestContents(ctx context.Context, tx *contentTx, documentIDs []string) ([]string, error) {
+	var bases []string
+	for _, chunk := range chunkValues(documentIDs) {
+		query, args, err := sqlx.In("SELECT DISTINCT c.hash, c.content, c.compressed_content, c.compression, c.encrypted_content, c.encryption_key_id, c.content_key, c.base_hash FROM "+documentsJoin+" WHERE d.id IN (?) AND c.base_hash IS NOT NULL AND d.version = (SELECT MAX(version) FROM documents WHERE id = d.id)", chunk)
+		if err != nil {
+			return nil, err
+		}
+		var contents []documentContent
+		if err = tx.SelectContext(ctx, &contents, tx.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+
+		for _, content := range contents {
+			full, err := d.getContent(ctx, tx, content.Hash, nil)
+			if err != nil {
+				return nil, err
+			}
+			row, err := d.writeContent(ctx, tx, content.Hash, full, nil)
+			if err != nil {
+				return nil, err
+			}
+			tx.removed = append(tx.removed, content.ContentKey)
+			if err = updateContent(ctx, tx, row); err != nil {
+				return nil, err
+			}
+			bases = append(bases, *content.BaseHash)
+		}
+	}
+	return bases, nil
+}
+
+// deleteUnreferencedContents deletes the contents of the hashes which are neither referenced by a version nor the base of another content.
+// Bases of deleted contents may become unreferenced as well, so they are checked until no more contents are deleted.
+func (d *DB) deleteUnreferencedContents(ctx context.Context, tx *contentTx, hashes []string) error {
+	for len(hashes) > 0 {
+		var bases []string
+		for _, chunk := range chunkValues(hashes) {
+			query, args, err := sqlx.In("DELETE FROM document_contents WHERE hash IN (?) AND NOT EXISTS (SELECT 1 FROM documents WHERE content_hash = document_contents.hash) AND NOT EXISTS (SELECT 1 FROM document_contents dependents WHERE dependents.base_hash = document_contents.hash) RETURNING hash, content_key, base_hash", chunk)
+			if err != nil {
+				return err
+			}
+			var contents []documentContent
+			if err = tx.SelectContext(ctx, &contents, tx.Rebind(query), args...); err != nil {
+				return err
+			}
+			for _, content := range contents {
+				tx.removed = append(tx.removed, content.ContentKey)
+				if content.BaseHash != nil {
+					bases = append(bases, *content.BaseHash)
+				}
+			}
+		}
+		hashes = bases
+	}
+	return nil
+}
+
+// chunkValues removes duplicates from the values and splits them into chunks of at most maxQueryValues.
+func chunkValues(values []string) [][]string {
+	values = append([]string(nil), values...)
+	slices.Sort(values)
+	values = slices.Compact(values)
+
+	var chunks [][]string
+	for len(values) > 0 {
+		chunk := values
+		if len(chunk) > maxQueryValues {
+			chunk = chunk[:maxQueryValues]
+		}
+		chunks = append(chunks, chunk)
+		values = values[len(chunk):]
+	}
+	return chunks
+}
+
+// deleteContent deletes blobs from the content storage.
+// The contents referencing them are already gone, so errors are only logged and leave unreferenced blobs behind.
+func (d *DB) deleteContent(contentKeys ...*string) {
+	if d.blobs == nil {
+		return
+	}
+	keys := make([]string, 0, len(contentKeys))
+	for _, key := range contentKeys {
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := d.blobs.Delete(context.Background(), keys); err != nil {
+		log.Println("failed to delete document content:", err)
+	}
+}
@@ -3,10 +3,14 @@ package gobin
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -22,11 +26,105 @@ import (
 
 var chars = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
 
+const (
+	// allTokensID is the token id used to revoke all tokens of a document.
+	allTokensID = "*"
+
+	// documentColumns selects a document version with its content, queries have to join documents as d with document_contents as c.
+	documentColumns = "d.id, d.version, d.created_at, d.legacy_version, c.content, d.language, d.expires_at, d.views_left, d.password_hash, d.encrypted, d.private, d.public, d.content_hash, c.compressed_content, c.compression, c.encrypted_content, c.encryption_key_id, c.content_key, c.base_hash"
+	documentsJoin   = "documents d JOIN document_contents c ON c.hash = d.content_hash"
+
+	// versionCondition matches the version $2 of the document $1 by its number or by its legacy version, preferring the number.
+	versionCondition = "d.id = $1 AND (d.version = $2 OR d.legacy_version = $2) ORDER BY d.version = $2 DESC LIMIT 1"
+
+	// notExpiredCondition excludes documents which expired before the cleanup deleted them, it has to be formatted with the parameter of the current unix time.
+	notExpiredCondition = "(d.expires_at IS NULL OR d.expires_at > %s)"
+)
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
+
+	// gobin_sha256 lets sqlite migrations hash contents like contentHash
+	if err := sqlite.RegisterDeterministicScalarFunction("gobin_sha256", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch arg := args[0].(type) {
+		case string:
+			return contentHash(arg), nil
+		case []byte:
+			return contentHash(string(arg)), nil
+		default:
+			return nil, errors.New("gobin_sha256 expects text")
+		}
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// NewSQLDB opens the postgres or sqlite database like OpenSQLDB and starts the cleanup & re-encryption.
+func NewSQLDB(ctx context.Context, cfg DatabaseConfig, migrations fs.FS, metrics *Metrics) (*DB, error) {
+	db, err := OpenSQLDB(ctx, cfg, migrations, metrics)
+	if err != nil {
+		return nil, err
+	}
+	db.cleanup = startCleanup(db, cfg, metrics)
+	if db.keyring != nil {
+		db.reencryption = startReencryption(db, cfg.Encryption.ReencryptInterval)
+	}
+	return db, nil
 }
 
-func NewDB(ctx context.Context, cfg DatabaseConfig, schema string) (*DB, error) {
+// OpenSQLDB connects to the postgres or sqlite database and applies pending migrations without starting any background work, for maintenance commands which run on their own.
+// Content is compressed with the configured compression, encrypted with the configured key and stored in the configured content storage instead of the database, if any.
+// The ctx only bounds connecting, migrations run until they are done as they may rewrite every document and can't be resumed halfway.
+func OpenSQLDB(ctx context.Context, cfg DatabaseConfig, migrations fs.FS, metrics *Metrics) (*DB, error) {
+	if err := validateCompression(cfg.Compression); err != nil {
+		return nil, err
+	}
+	keys, err := newKeyring(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	dbx, err := connectSQL(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := newMigrator(dbx, cfg.Type, migrations)
+	if err != nil {
+		_ = dbx.Close()
+		return nil, err
+	}
+	if cfg.SkipMigrations {
+		err = migrator.Check(ctx)
+	} else {
+		var applied int
+		if applied, err = migrator.Up(context.Background()); applied > 0 {
+			log.Printf("Applied %d database migrations", applied)
+		}
+	}
+	if err != nil {
+		_ = dbx.Close()
+		return nil, err
+	}
+
+	blobs, err := NewBlobStore(ctx, cfg.ContentStorage)
+	if err != nil {
+		_ = dbx.Close()
+		return nil, err
+	}
+
+	return &DB{
+		dbx:         dbx,
+		dbType:      cfg.Type,
+		compression: cfg.Compression,
+		keyring:     keys,
+		blobs:       blobs,
+		metrics:     metrics,
+	}, nil
+}
+
+// connectSQL connects to the postgres or sqlite database.
+func connectSQL(ctx context.Context, cfg DatabaseConfig) (*sqlx.DB, error) {
 	var (
 		driverName     string
 		dataSourceName string
@@ -50,148 +148,320 @@ func NewDB(ctx context.Context, cfg DatabaseConfig, schema string) (*DB, error)
 		dataSourceName = stdlib.RegisterConnConfig(pgCfg)
 	case "sqlite":
 		driverName = "sqlite"
-		dataSourceName = cfg.Path
+		dataSourceName = sqliteDataSourceName(cfg.Path)
 	default:
-		return nil, errors.New("invalid database type, must be one of: postgres, sqlite")
-	}
-	dbx, err := sqlx.ConnectContext(ctx, driverName, dataSourceName)
-	if err != nil {
-		return nil, err
+		return nil, errors.New("invalid sql database type, must be one of: postgres, sqlite")
 	}
+	return sqlx.ConnectContext(ctx, driverName, dataSourceName)
+}
 
-	// execute schema
-	if _, err = dbx.ExecContext(ctx, schema); err != nil {
-		return nil, err
+// sqliteDataSourceName makes sqlite wait for locks and begin transactions with a write lock, unless the path configures this itself.
+// Deferred transactions which read before they write can't wait for a lock held by another writer and fail with SQLITE_BUSY instead.
+func sqliteDataSourceName(path string) string {
+	var params []string
+	if !strings.Contains(path, "busy_timeout") {
+		params = append(params, "_pragma=busy_timeout(5000)")
 	}
-
-	cleanupContext, cancel := context.WithCancel(context.Background())
-	db := &DB{
-		dbx:           dbx,
-		cleanupCancel: cancel,
+	if !strings.Contains(path, "_txlock=") {
+		params = append(params, "_txlock=immediate")
+	}
+	if len(params) == 0 {
+		return path
 	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + strings.Join(params, "&")
+}
 
-	go db.cleanup(cleanupContext, cfg.CleanupInterval, cfg.ExpireAfter)
+type Document struct {
+	ID string `db:"id"`
+	// Version numbers the versions of a document, starting at 1 with the first version
+	Version int64 `db:"version"`
+	// CreatedAt is the unix time the version was created at
+	CreatedAt int64 `db:"created_at"`
+	// LegacyVersion is the unix second version of versions created before versions were numbered, it keeps old links working
+	LegacyVersion *int64  `db:"legacy_version"`
+	Content       string  `db:"content"`
+	Language      string  `db:"language"`
+	ExpiresAt     *int64  `db:"expires_at"`
+	ViewsLeft     *int64  `db:"views_left"`
+	PasswordHash  *string `db:"password_hash"`
+	Encrypted     bool    `db:"encrypted"`
+	Private       bool    `db:"private"`
+	Public        bool    `db:"public"`
+	// ContentHash is the hex encoded SHA-256 hash of the content, versions with the same content share it
+	ContentHash string `db:"content_hash"`
+	// CompressedContent is the compressed content if Compression is set, the content column is empty then
+	CompressedContent []byte  `db:"compressed_content"`
+	Compression       *string `db:"compression"`
+	// EncryptedContent is the encrypted content if EncryptionKeyID is set, the content columns are empty then
+	EncryptedContent []byte  `db:"encrypted_content"`
+	EncryptionKeyID  *string `db:"encryption_key_id"`
+	// ContentKey references the content in the content storage, the content column is empty then
+	ContentKey *string `db:"content_key"`
+	// BaseHash references the content the content is stored as delta of
+	BaseHash *string `db:"base_hash"`
+}
 
-	return db, nil
+// DocumentUpdate is the metadata UpdateDocument changes together with the new version, fields are only applied if their Update flag is set.
+type DocumentUpdate struct {
+	UpdateExpiresAt bool
+	ExpiresAt       *int64
+	UpdatePassword  bool
+	// PasswordHash removes the password if it's nil
+	PasswordHash     *string
+	UpdateVisibility bool
+	Private          bool
+	Public           bool
 }
 
-type Document struct {
-	ID       string `db:"id"`
-	Version  int64  `db:"version"`
-	Content  string `db:"content"`
-	Language string `db:"language"`
+// apply applies the metadata to a version of the document.
+func (u DocumentUpdate) apply(doc *Document) {
+	if u.UpdateExpiresAt {
+		doc.ExpiresAt = u.ExpiresAt
+	}
+	if u.UpdatePassword {
+		doc.PasswordHash = u.PasswordHash
+	}
+	if u.UpdateVisibility {
+		doc.Private = u.Private
+		doc.Public = u.Public
+	}
 }
 
+// DB is the Store backed by a postgres or sqlite database.
 type DB struct {
-	dbx           *sqlx.DB
-	cleanupCancel context.CancelFunc
+	dbx          *sqlx.DB
+	dbType       string
+	compression  string
+	keyring      *keyring
+	blobs        BlobStore
+	metrics      *Metrics
+	cleanup      *cleanupLoop
+	reencryption *cleanupLoop
+	closeOnce    sync.Once
+	closeErr     error
 }
 
+// Close stops the cleanup & re-encryption, waits for them to finish and closes the database.
+// It is safe to call Close multiple times.
 func (d *DB) Close() error {
-	return d.dbx.Close()
+	d.closeOnce.Do(func() {
+		if d.cleanup != nil {
+			d.cleanup.stop()
+		}
+		if d.reencryption != nil {
+			d.reencryption.stop()
+		}
+		d.closeErr = d.dbx.Close()
+	})
+	return d.closeErr
 }
 
 func (d *DB) GetDocument(ctx context.Context, documentID string) (Document, error) {
+	defer d.metrics.ObserveQuery("get_document", time.Now())
 	var doc Document
-	err := d.dbx.GetContext(ctx, &doc, "SELECT * FROM documents WHERE id = $1 ORDER BY version DESC LIMIT 1", documentID)
-	return doc, err
+	if err := d.dbx.GetContext(ctx, &doc, "SELECT "+documentColumns+" FROM "+documentsJoin+" WHERE d.id = $1 AND "+fmt.Sprintf(notExpiredCondition, "$2")+" ORDER BY d.version DESC LIMIT 1", documentID, time.Now().Unix()); err != nil {
+		return Document{}, err
+	}
+	return doc, d.loadContent(ctx, d.dbx, &doc, nil)
 }
 
 func (d *DB) GetDocumentVersion(ctx context.Context, documentID string, version int64) (Document, error) {
+	defer d.metrics.ObserveQuery("get_document_version", time.Now())
 	var doc Document
-	err := d.dbx.GetContext(ctx, &doc, "SELECT * FROM documents WHERE id = $1 AND version = $2", documentID, version)
-	return doc, err
+	if err := d.dbx.GetContext(ctx, &doc, "SELECT "+documentColumns+" FROM "+documentsJoin+" WHERE "+fmt.Sprintf(notExpiredCondition, "$3")+" AND "+versionCondition, documentID, version, time.Now().Unix()); err != nil {
+		return Document{}, err
+	}
+	return doc, d.loadContent(ctx, d.dbx, &doc, nil)
 }
 
 func (d *DB) GetDocumentVersions(ctx context.Context, documentID string, withContent bool) ([]Document, error) {
+	defer d.metrics.ObserveQuery("get_document_versions", time.Now())
 	var docs []Document
 	var sqlString string
 	if withContent {
-		sqlString = "SELECT id, version, content, language FROM documents where id = $1 ORDER BY version DESC"
+		sqlString = "SELECT " + documentColumns + " FROM " + documentsJoin + " WHERE d.id = $1 AND " + fmt.Sprintf(notExpiredCondition, "$2") + " ORDER BY d.version DESC"
 	} else {
-		sqlString = "SELECT id, version FROM documents where id = $1 ORDER BY version DESC"
+		sqlString = "SELECT d.id, d.version, d.created_at, d.legacy_version, d.expires_at, d.views_left, d.password_hash, d.encrypted, d.private, d.public, d.content_hash FROM documents d WHERE d.id = $1 AND " + fmt.Sprintf(notExpiredCondition, "$2") + " ORDER BY d.version DESC"
+	}
+	if err := d.dbx.SelectContext(ctx, &docs, sqlString, documentID, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	if !withContent {
+		return docs, nil
 	}
-	err := d.dbx.SelectContext(ctx, &docs, sqlString, documentID)
-	return docs, err
+	// older versions are stored as delta of newer ones, so the contents are reconstructed from newest to oldest
+	contents := map[string]string{}
+	for i := range docs {
+		if err := d.loadContent(ctx, d.dbx, &docs[i], contents); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
 }
 
 func (d *DB) DeleteDocumentByVersion(ctx context.Context, documentID string, version int64) error {
-	res, err := d.dbx.ExecContext(ctx, "DELETE FROM documents WHERE id = $1 AND version = $2", documentID, version)
-	if err != nil {
-		return err
-	}
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rows == 0 {
+	defer d.metrics.ObserveQuery("delete_document_by_version", time.Now())
+	rows, err := d.deleteDocuments(ctx, "DELETE FROM documents WHERE id = $1 AND version = (SELECT d.version FROM documents d WHERE "+versionCondition+") RETURNING id, content_hash", documentID, version)
+	if err == nil && rows == 0 {
 		return sql.ErrNoRows
 	}
-	return nil
+	return err
 }
 
 func (d *DB) GetVersionCount(ctx context.Context, documentID string) (int, error) {
+	defer d.metrics.ObserveQuery("get_version_count", time.Now())
 	var count int
 	err := d.dbx.GetContext(ctx, &count, "SELECT COUNT(*) FROM documents WHERE id = $1", documentID)
 	return count, err
 }
 
-func (d *DB) CreateDocument(ctx context.Context, content string, language string) (Document, error) {
-	return d.createDocument(ctx, content, language, 0)
+// CreateDocument creates a new document with a random ID as version 1.
+func (d *DB) CreateDocument(ctx context.Context, document Document) (Document, error) {
+	defer d.metrics.ObserveQuery("create_document", time.Now())
+	return d.createDocument(ctx, document, 0)
 }
 
-func (d *DB) createDocument(ctx context.Context, content string, language string, try int) (Document, error) {
+func (d *DB) createDocument(ctx context.Context, document Document, try int) (Document, error) {
 	if try >= 10 {
 		return Document{}, errors.New("failed to create document because of duplicate key after 10 tries")
 	}
-	now := time.Now().Unix()
-	doc := Document{
-		ID:       randomString(8),
-		Content:  content,
-		Language: language,
-		Version:  now,
+	doc := document
+	doc.ID = randomString(8)
+	doc.Version = 1
+	doc.CreatedAt = time.Now().Unix()
+	doc.LegacyVersion = nil
+
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return Document{}, err
 	}
-	_, err := d.dbx.NamedExecContext(ctx, "INSERT INTO documents (id, version, content, language) VALUES (:id, :version, :content, :language) RETURNING *", doc)
+	defer d.rollback(tx)
 
+	if doc.ContentHash, err = d.storeContent(ctx, tx, doc.Content); err != nil {
+		return Document{}, err
+	}
+	if _, err = tx.NamedExecContext(ctx, "INSERT INTO document_sequences (id, last_version) VALUES (:id, :version)", doc); err == nil {
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO documents (id, version, created_at, content_hash, language, expires_at, views_left, password_hash, encrypted, private, public) VALUES (:id, :version, :created_at, :content_hash, :language, :expires_at, :views_left, :password_hash, :encrypted, :private, :public)", doc)
+	}
 	if err != nil {
-		var (
-			sqliteErr *sqlite.Error
-			pgErr     *pgconn.PgError
-		)
-		if errors.As(err, &sqliteErr) || errors.As(err, &pgErr) {
-			if (sqliteErr != nil && sqliteErr.Code() == 1555) || (pgErr != nil && pgErr.Code == "23505") {
-				return d.createDocument(ctx, content, language, try+1)
-			}
+		d.rollback(tx)
+		if isUniqueViolation(err) {
+			return d.createDocument(ctx, document, try+1)
 		}
+		return Document{}, err
 	}
-
-	return doc, err
+	if _, err = d.indexDocuments(ctx, tx, []string{doc.ID}, map[string]string{doc.ContentHash: doc.Content}); err != nil {
+		return Document{}, err
+	}
+	if err = d.commit(tx); err != nil {
+		return Document{}, err
+	}
+	return doc, nil
 }
 
-func (d *DB) UpdateDocument(ctx context.Context, documentID string, content string, language string) (Document, error) {
-	doc := Document{
-		ID:       documentID,
-		Version:  time.Now().Unix(),
-		Content:  content,
-		Language: language,
+func (d *DB) UpdateDocument(ctx context.Context, documentID string, content string, language string, matchVersion int64, update DocumentUpdate) (Document, error) {
+	defer d.metrics.ObserveQuery("update_document", time.Now())
+	// the previous version becomes a delta of the new one, encoding it before the transaction keeps the write lock short
+	var latest Document
+	if err := d.dbx.GetContext(ctx, &latest, "SELECT "+documentColumns+" FROM "+documentsJoin+" WHERE d.id = $1 ORDER BY d.version DESC LIMIT 1", documentID); err != nil {
+		return Document{}, err
 	}
-	res, err := d.dbx.NamedExecContext(ctx, "INSERT INTO documents (id, version, content, language) VALUES (:id, :version, :content, :language)", doc)
-	if err != nil {
+	if err := d.loadContent(ctx, d.dbx, &latest, nil); err != nil {
 		return Document{}, err
 	}
-	rows, err := res.RowsAffected()
+	var delta string
+	if latest.ContentHash != contentHash(content) && canEncodeDelta(content, latest.Content) {
+		delta = encodeDelta(content, latest.Content)
+	}
+
+	tx, err := d.beginContentTx(ctx)
 	if err != nil {
 		return Document{}, err
 	}
-	if rows == 0 {
-		return Document{}, sql.ErrNoRows
+	defer d.rollback(tx)
+
+	// counting up the sequence also locks it, so concurrent updates of the document are serialized
+	// documents without a sequence continue after their latest version, if the document doesn't exist no sequence is created
+	var version int64
+	if err = tx.GetContext(ctx, &version, "INSERT INTO document_sequences (id, last_version) SELECT id, MAX(version) + 1 FROM documents WHERE id = $1 GROUP BY id ON CONFLICT (id) DO UPDATE SET last_version = document_sequences.last_version + 1 RETURNING last_version", documentID); err != nil {
+		return Document{}, err
+	}
+	var previous Document
+	if err = tx.GetContext(ctx, &previous, "SELECT version, content_hash FROM documents WHERE id = $1 ORDER BY version DESC LIMIT 1", documentID); err != nil {
+		return Document{}, err
+	}
+	if matchVersion != 0 && previous.Version != matchVersion {
+		return Document{}, ErrVersionConflict
+	}
+	// the metadata is updated before the new version is inserted, so no version is ever visible with the old metadata
+	if err = d.updateDocumentMetadata(ctx, tx, documentID, update); err != nil {
+		return Document{}, err
+	}
+	hash, err := d.storeContent(ctx, tx, content)
+	if err != nil {
+		return Document{}, err
 	}
 
+	var doc Document
+	// the new version inherits the expiration, views, password & encryption of the latest version, this also makes sure we don't recreate deleted documents
+	if err = tx.GetContext(ctx, &doc, "INSERT INTO documents (id, version, created_at, content_hash, language, expires_at, views_left, password_hash, encrypted, private, public) SELECT id, CAST($2 AS BIGINT), CAST($3 AS BIGINT), $4, $5, expires_at, views_left, password_hash, encrypted, private, public FROM documents WHERE id = $1 ORDER BY version DESC LIMIT 1 RETURNING id, version, created_at, content_hash, language, expires_at, views_left, password_hash, encrypted, private, public", documentID, version, time.Now().Unix(), hash, language); err != nil {
+		return Document{}, err
+	}
+	// the previous version is no longer the latest, so it can be stored as delta of the new one
+	// if another update got in between, the delta doesn't belong to the previous version and it stays stored in full
+	if delta != "" && previous.ContentHash == latest.ContentHash {
+		current, ok, err := d.deltaCandidate(ctx, tx, previous.ContentHash, hash)
+		if err != nil {
+			return Document{}, err
+		}
+		if ok {
+			if _, err = d.storeDelta(ctx, tx, current, hash, latest.Content, delta); err != nil {
+				return Document{}, err
+			}
+		}
+	}
+	if _, err = d.indexDocuments(ctx, tx, []string{documentID}, map[string]string{hash: content, latest.ContentHash: latest.Content}); err != nil {
+		return Document{}, err
+	}
+	if err = d.commit(tx); err != nil {
+		return Document{}, err
+	}
+	doc.Content = content
 	return doc, nil
 }
 
-func (d *DB) DeleteDocument(ctx context.Context, documentID string) error {
-	res, err := d.dbx.ExecContext(ctx, "DELETE FROM documents WHERE id = $1", documentID)
+// updateDocumentMetadata updates the metadata of all versions of a document.
+func (d *DB) updateDocumentMetadata(ctx context.Context, tx *contentTx, documentID string, update DocumentUpdate) error {
+	var (
+		columns []string
+		args    = []any{documentID}
+	)
+	if update.UpdateExpiresAt {
+		args = append(args, update.ExpiresAt)
+		columns = append(columns, fmt.Sprintf("expires_at = $%d", len(args)))
+	}
+	if update.UpdatePassword {
+		args = append(args, update.PasswordHash)
+		columns = append(columns, fmt.Sprintf("password_hash = $%d", len(args)))
+	}
+	if update.UpdateVisibility {
+		args = append(args, update.Private, update.Public)
+		columns = append(columns, fmt.Sprintf("private = $%d, public = $%d", len(args)-1, len(args)))
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE documents SET "+strings.Join(columns, ", ")+" WHERE id = $1", args...)
+	return err
+}
+
+func (d *DB) UpdateDocumentExpiration(ctx context.Context, documentID string, expiresAt *int64) error {
+	defer d.metrics.ObserveQuery("update_document_expiration", time.Now())
+	res, err := d.dbx.ExecContext(ctx, "UPDATE documents SET expires_at = $2 WHERE id = $1", documentID, expiresAt)
 	if err != nil {
 		return err
 	}
@@ -202,41 +472,173 @@ func (d *DB) DeleteDocument(ctx context.Context, documentID string) error {
 	if rows == 0 {
 		return sql.ErrNoRows
 	}
+	return nil
+}
 
+func (d *DB) UpdateDocumentPassword(ctx context.Context, documentID string, passwordHash *string) error {
+	defer d.metrics.ObserveQuery("update_document_password", time.Now())
+	res, err := d.dbx.ExecContext(ctx, "UPDATE documents SET password_hash = $2 WHERE id = $1", documentID, passwordHash)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func (d *DB) DeleteExpiredDocuments(ctx context.Context, expireAfter time.Duration) error {
-	_, err := d.dbx.ExecContext(ctx, "DELETE FROM documents WHERE version < $1", time.Now().Add(expireAfter).Unix())
-	return err
+// UpdateDocumentVisibility updates if a document is private or public, a document can't be both.
+func (d *DB) UpdateDocumentVisibility(ctx context.Context, documentID string, private bool, public bool) error {
+	defer d.metrics.ObserveQuery("update_document_visibility", time.Now())
+	res, err := d.dbx.ExecContext(ctx, "UPDATE documents SET private = $2, public = $3 WHERE id = $1", documentID, private, public)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (d *DB) cleanup(ctx context.Context, cleanUpInterval time.Duration, expireAfter time.Duration) {
-	if expireAfter <= 0 {
-		return
+// ViewDocument atomically counts a view of a view limited document and returns the views left.
+// The document with all its versions is deleted once no views are left.
+// sql.ErrNoRows is returned if the document has no views left.
+func (d *DB) ViewDocument(ctx context.Context, documentID string) (int64, error) {
+	defer d.metrics.ObserveQuery("view_document", time.Now())
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.rollback(tx)
+
+	var viewsLeft []int64
+	if err = tx.SelectContext(ctx, &viewsLeft, "UPDATE documents SET views_left = views_left - 1 WHERE id = $1 AND views_left > 0 RETURNING views_left", documentID); err != nil {
+		return 0, err
 	}
-	if cleanUpInterval <= 0 {
-		cleanUpInterval = 10 * time.Minute
+	if len(viewsLeft) == 0 {
+		return 0, sql.ErrNoRows
 	}
-	log.Println("Starting document cleanup...")
-	ticker := time.NewTicker(cleanUpInterval)
-	defer ticker.Stop()
-	defer log.Println("document cleanup stopped")
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			err := d.DeleteExpiredDocuments(ctx, expireAfter)
-			if errors.Is(err, context.Canceled) {
-				return
-			}
-			if err != nil {
-				log.Println("failed to delete expired documents:", err)
-			}
+	if viewsLeft[0] <= 0 {
+		if _, err = d.deleteVersions(ctx, tx, "DELETE FROM documents WHERE id = $1 RETURNING id, content_hash", documentID); err != nil {
+			return 0, err
 		}
 	}
+	if err = d.commit(tx); err != nil {
+		return 0, err
+	}
+	return viewsLeft[0], nil
+}
+
+func (d *DB) DeleteDocument(ctx context.Context, documentID string, matchVersion int64) error {
+	defer d.metrics.ObserveQuery("delete_document", time.Now())
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer d.rollback(tx)
+
+	if matchVersion != 0 {
+		// touching the sequence locks it like UpdateDocument does, so the latest version can't change until the document is deleted
+		if _, err = tx.ExecContext(ctx, "UPDATE document_sequences SET last_version = last_version WHERE id = $1", documentID); err != nil {
+			return err
+		}
+		var latestVersion int64
+		if err = tx.GetContext(ctx, &latestVersion, "SELECT version FROM documents WHERE id = $1 ORDER BY version DESC LIMIT 1", documentID); err != nil {
+			return err
+		}
+		if latestVersion != matchVersion {
+			return ErrVersionConflict
+		}
+	}
+	rows, err := d.deleteVersions(ctx, tx, "DELETE FROM documents WHERE id = $1 RETURNING id, content_hash", documentID)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return d.commit(tx)
+}
+
+// deleteDocuments executes the delete query in a transaction, see deleteVersions.
+func (d *DB) deleteDocuments(ctx context.Context, query string, args ...any) (int64, error) {
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.rollback(tx)
+
+	rows, err := d.deleteVersions(ctx, tx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return rows, d.commit(tx)
+}
+
+// RevokeToken revokes a single token of a document.
+// The revocation is kept until the token expires or the document is deleted.
+func (d *DB) RevokeToken(ctx context.Context, documentID string, tokenID string, expiresAt *int64) error {
+	defer d.metrics.ObserveQuery("revoke_token", time.Now())
+	_, err := d.dbx.ExecContext(ctx, "INSERT INTO revoked_tokens (document_id, token_id, revoked_at, expires_at) VALUES ($1, $2, $3, $4) ON CONFLICT (document_id, token_id) DO NOTHING", documentID, tokenID, time.Now().Unix(), expiresAt)
+	return err
+}
+
+// RevokeAllTokens revokes all tokens of a document which were issued before revokedAt.
+func (d *DB) RevokeAllTokens(ctx context.Context, documentID string, revokedAt int64) error {
+	defer d.metrics.ObserveQuery("revoke_all_tokens", time.Now())
+	_, err := d.dbx.ExecContext(ctx, "INSERT INTO revoked_tokens (document_id, token_id, revoked_at) VALUES ($1, $2, $3) ON CONFLICT (document_id, token_id) DO UPDATE SET revoked_at = excluded.revoked_at", documentID, allTokensID, revokedAt)
+	return err
+}
+
+// IsTokenRevoked checks if the token was revoked by itself or by revoking all tokens of the document.
+func (d *DB) IsTokenRevoked(ctx context.Context, documentID string, tokenID string, issuedAt int64) (bool, error) {
+	defer d.metrics.ObserveQuery("is_token_revoked", time.Now())
+	var revoked bool
+	err := d.dbx.GetContext(ctx, &revoked, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE document_id = $1 AND (token_id = $2 OR (token_id = $3 AND revoked_at > $4)))", documentID, tokenID, allTokensID, issuedAt)
+	return revoked, err
+}
+
+// DeleteExpiredDocuments deletes all documents which expired and returns the number of deleted rows.
+// Documents without an expiration are deleted expireAfter after their version was created, if expireAfter is greater than 0.
+func (d *DB) DeleteExpiredDocuments(ctx context.Context, expireAfter time.Duration) (int64, error) {
+	defer d.metrics.ObserveQuery("delete_expired_documents", time.Now())
+	now := time.Now()
+	var minCreatedAt int64
+	if expireAfter > 0 {
+		minCreatedAt = now.Add(-expireAfter).Unix()
+	}
+	return d.deleteDocuments(ctx, "DELETE FROM documents WHERE expires_at <= $1 OR (expires_at IS NULL AND created_at < $2) RETURNING id, content_hash", now.Unix(), minCreatedAt)
+}
+
+// DeleteExpiredTokenRevocations deletes revocations of tokens which expired or belong to deleted documents and returns the number of deleted rows.
+func (d *DB) DeleteExpiredTokenRevocations(ctx context.Context) (int64, error) {
+	defer d.metrics.ObserveQuery("delete_expired_token_revocations", time.Now())
+	res, err := d.dbx.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= $1 OR document_id NOT IN (SELECT id FROM documents)", time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// isUniqueViolation checks if the error is a unique constraint violation of sqlite or postgres.
+func isUniqueViolation(err error) bool {
+	var (
+		sqliteErr *sqlite.Error
+		pgErr     *pgconn.PgError
+	)
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == 1555 || sqliteErr.Code() == 2067
+	}
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
 }
 
 func randomString(length int) string {
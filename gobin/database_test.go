@@ -0,0 +1,84 @@
+package gobin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteDB creates a sqlite database in a temporary directory, its cleanup doesn't run during tests.
+func newTestSQLiteDB(t testing.TB, cfg DatabaseConfig) *DB {
+	t.Helper()
+	cfg.Type = "sqlite"
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "gobin.db")
+	}
+	cfg.CleanupInterval = time.Hour
+	db, err := NewSQLDB(context.Background(), cfg, os.DirFS("../sql/migrations"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func TestSQLiteConcurrentWriters(t *testing.T) {
+	db := newTestSQLiteDB(t, DatabaseConfig{})
+	ctx := context.Background()
+
+	const writers = 40
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, writers)
+	)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			document, err := db.CreateDocument(ctx, Document{Content: "writer " + strconv.Itoa(i), Language: "plaintext"})
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 0; j < 5; j++ {
+				if document, err = db.UpdateDocument(ctx, document.ID, "update "+strconv.Itoa(j)+" by writer "+strconv.Itoa(i), "plaintext", document.Version, DocumentUpdate{}); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if i%2 == 0 {
+				errs <- db.DeleteDocument(ctx, document.ID, 0)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSQLiteDataSourceName(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		want string
+	}{
+		{"gobin.db", "gobin.db?_pragma=busy_timeout(5000)&_txlock=immediate"},
+		{"file:gobin.db?cache=shared", "file:gobin.db?cache=shared&_pragma=busy_timeout(5000)&_txlock=immediate"},
+		{"gobin.db?_pragma=busy_timeout(10000)", "gobin.db?_pragma=busy_timeout(10000)&_txlock=immediate"},
+		{"gobin.db?_txlock=exclusive&_pragma=busy_timeout(10000)", "gobin.db?_txlock=exclusive&_pragma=busy_timeout(10000)"},
+	} {
+		if got := sqliteDataSourceName(c.path); got != c.want {
+			t.Errorf("sqliteDataSourceName(%q) = %q, want %q", c.path, got, c.want)
+		}
+	}
+}
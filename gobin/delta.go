@@ -0,0 +1,96 @@
+package gobin
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// maxDeltaSize is the size in bytes above which contents are never encoded as delta, matching the lines of large contents can take seconds.
+const maxDeltaSize = 512 << 10
+
+var ErrInvalidDelta = errors.New("invalid delta")
+
+// canEncodeDelta checks if the content is small enough to be encoded as delta of base, larger contents are stored in full.
+func canEncodeDelta(base string, content string) bool {
+	return len(base) <= maxDeltaSize && len(content) <= maxDeltaSize
+}
+
+// encodeDelta encodes content as line based delta of base.
+// The delta is a sequence of operations which either copy a byte range of base ("c<offset>,<length>\n") or insert the following bytes ("i<length>\n<bytes>").
+// Only whole lines are inserted, so the delta of valid UTF-8 is valid UTF-8 as well.
+func encodeDelta(base string, content string) string {
+	baseLines := splitLines(base)
+	contentLines := splitLines(content)
+
+	offsets := make([]int, len(baseLines)+1)
+	for i, line := range baseLines {
+		offsets[i+1] = offsets[i] + len(line)
+	}
+
+	var (
+		delta strings.Builder
+		next  int
+	)
+	for _, match := range difflib.NewMatcher(baseLines, contentLines).GetMatchingBlocks() {
+		if match.B > next {
+			insert := strings.Join(contentLines[next:match.B], "")
+			delta.WriteString("i" + strconv.Itoa(len(insert)) + "\n" + insert)
+		}
+		if match.Size > 0 {
+			delta.WriteString("c" + strconv.Itoa(offsets[match.A]) + "," + strconv.Itoa(offsets[match.A+match.Size]-offsets[match.A]) + "\n")
+		}
+		next = match.B + match.Size
+	}
+	return delta.String()
+}
+
+// applyDelta reconstructs the content of a delta created by encodeDelta from its base.
+func applyDelta(base string, delta string) (string, error) {
+	var content strings.Builder
+	for len(delta) > 0 {
+		op := delta[0]
+		header, rest, ok := strings.Cut(delta[1:], "\n")
+		if !ok {
+			return "", ErrInvalidDelta
+		}
+		switch op {
+		case 'c':
+			offsetStr, lengthStr, ok := strings.Cut(header, ",")
+			if !ok {
+				return "", ErrInvalidDelta
+			}
+			offset, err := strconv.Atoi(offsetStr)
+			if err != nil {
+				return "", ErrInvalidDelta
+			}
+			length, err := strconv.Atoi(lengthStr)
+			if err != nil || offset < 0 || length < 0 || offset+length > len(base) {
+				return "", ErrInvalidDelta
+			}
+			content.WriteString(base[offset : offset+length])
+			delta = rest
+		case 'i':
+			length, err := strconv.Atoi(header)
+			if err != nil || length < 0 || length > len(rest) {
+				return "", ErrInvalidDelta
+			}
+			content.WriteString(rest[:length])
+			delta = rest[length:]
+		default:
+			return "", ErrInvalidDelta
+		}
+	}
+	return content.String(), nil
+}
+
+// splitLines splits s after every newline, joining the lines results in s again.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
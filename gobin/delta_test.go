@@ -0,0 +1,195 @@
+package gobin
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// deltaVersions returns count versions of a document with the given number of lines, every version changes a few lines of the one before.
+func deltaVersions(lines int, count int) []string {
+	content := make([]string, lines)
+	for i := range content {
+		content[i] = "line " + strconv.Itoa(i) + " of the document\n"
+	}
+	versions := make([]string, count)
+	for v := range versions {
+		for i := v; i < lines; i += lines / 4 {
+			content[i] = "line " + strconv.Itoa(i) + " changed in version " + strconv.Itoa(v) + "\n"
+		}
+		versions[v] = strings.Join(content, "")
+	}
+	return versions
+}
+
+func TestDelta(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		base    string
+		content string
+	}{
+		{"empty", "", ""},
+		{"empty base", "", "a\nb\n"},
+		{"empty content", "a\nb\n", ""},
+		{"no trailing newline", "a\nb", "a\nc"},
+		{"unicode", "ä\nö\n", "ä\nü\nö\n"},
+	} {
+		delta := encodeDelta(c.base, c.content)
+		got, err := applyDelta(c.base, delta)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if got != c.content {
+			t.Errorf("%s: expected %q, got %q", c.name, c.content, got)
+		}
+	}
+
+	versions := deltaVersions(100, deltaSnapshotInterval)
+	for i := 0; i+1 < len(versions); i++ {
+		delta := encodeDelta(versions[i+1], versions[i])
+		if len(delta) > len(versions[i])/2 {
+			t.Errorf("expected the delta of version %d to be smaller than half its content, got %d of %d bytes", i, len(delta), len(versions[i]))
+		}
+		got, err := applyDelta(versions[i+1], delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != versions[i] {
+			t.Fatalf("version %d doesn't match its delta", i)
+		}
+	}
+}
+
+func TestLargeVersionsStoredInFull(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t, DatabaseConfig{})
+	versions := deltaVersions(maxDeltaSize/20, 2)
+	document, err := db.CreateDocument(ctx, Document{Content: versions[0], Language: "plaintext"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.UpdateDocument(ctx, document.ID, versions[1], "plaintext", 0, DocumentUpdate{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas int
+	if err = db.dbx.GetContext(ctx, &deltas, "SELECT COUNT(*) FROM document_contents WHERE base_hash IS NOT NULL"); err != nil {
+		t.Fatal(err)
+	}
+	if deltas != 0 {
+		t.Fatalf("expected versions larger than %d bytes to be stored in full, got %d deltas", maxDeltaSize, deltas)
+	}
+}
+
+func BenchmarkEncodeDelta(b *testing.B) {
+	for _, lines := range []int{100, 1000, 10000} {
+		versions := deltaVersions(lines, 2)
+		b.Run(strconv.Itoa(lines)+" lines", func(b *testing.B) {
+			b.SetBytes(int64(len(versions[0])))
+			for i := 0; i < b.N; i++ {
+				encodeDelta(versions[1], versions[0])
+			}
+		})
+	}
+}
+
+// BenchmarkApplyDeltaChain reconstructs the oldest version of the longest delta chain from the version stored in full.
+func BenchmarkApplyDeltaChain(b *testing.B) {
+	for _, lines := range []int{100, 1000, 10000} {
+		versions := deltaVersions(lines, deltaSnapshotInterval)
+		deltas := make([]string, len(versions)-1)
+		for i := range deltas {
+			deltas[i] = encodeDelta(versions[i+1], versions[i])
+		}
+		b.Run(strconv.Itoa(lines)+" lines", func(b *testing.B) {
+			b.SetBytes(int64(len(versions[0])))
+			for i := 0; i < b.N; i++ {
+				content := versions[len(versions)-1]
+				for j := len(deltas) - 1; j >= 0; j-- {
+					var err error
+					if content, err = applyDelta(content, deltas[j]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDeltaChain updates a sqlite document until its first version is at the end of the longest delta chain and reads this version.
+// The read benchmark reports the bytes stored for all versions of the document against the bytes of storing every version in full, with and without compression.
+func BenchmarkDeltaChain(b *testing.B) {
+	for _, compression := range []string{"", "zstd"} {
+		name := compression
+		if name == "" {
+			name = "uncompressed"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkDeltaChain(b, compression)
+		})
+	}
+}
+
+func benchmarkDeltaChain(b *testing.B, compression string) {
+	ctx := context.Background()
+	versions := deltaVersions(1000, deltaSnapshotInterval)
+	db := newTestSQLiteDB(b, DatabaseConfig{Compression: compression})
+
+	b.Run("update", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			document, err := db.CreateDocument(ctx, Document{Content: versions[0], Language: "plaintext"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, version := range versions[1:] {
+				if _, err = db.UpdateDocument(ctx, document.ID, version, "plaintext", 0, DocumentUpdate{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	document, err := db.CreateDocument(ctx, Document{Content: versions[0], Language: "plaintext"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, version := range versions[1:] {
+		if _, err = db.UpdateDocument(ctx, document.ID, version, "plaintext", 0, DocumentUpdate{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var depth int
+	if err = db.dbx.GetContext(ctx, &depth, "WITH RECURSIVE chain (hash, base_hash) AS (SELECT c.hash, c.base_hash FROM "+documentsJoin+" WHERE d.id = $1 AND d.version = 1 UNION ALL SELECT c.hash, c.base_hash FROM document_contents c JOIN chain ON c.hash = chain.base_hash) SELECT COUNT(*) - 1 FROM chain", document.ID); err != nil {
+		b.Fatal(err)
+	}
+	if depth != deltaSnapshotInterval-1 {
+		b.Fatalf("expected the first version at depth %d of the delta chain, got %d", deltaSnapshotInterval-1, depth)
+	}
+
+	var stored int64
+	if err = db.dbx.GetContext(ctx, &stored, "SELECT SUM(LENGTH(CAST(content AS BLOB)) + COALESCE(LENGTH(compressed_content), 0)) FROM document_contents WHERE hash IN (SELECT content_hash FROM documents WHERE id = $1)", document.ID); err != nil {
+		b.Fatal(err)
+	}
+	var full int
+	for _, version := range versions {
+		full += len(version)
+	}
+
+	b.Run("read", func(b *testing.B) {
+		b.SetBytes(int64(len(versions[0])))
+		for i := 0; i < b.N; i++ {
+			first, err := db.GetDocumentVersion(ctx, document.ID, 1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if first.Content != versions[0] {
+				b.Fatal("the first version doesn't match its content")
+			}
+		}
+		b.ReportMetric(float64(stored), "stored-bytes")
+		b.ReportMetric(float64(full), "full-bytes")
+		b.ReportMetric(float64(stored)/float64(full), "stored/full")
+	})
+}
@@ -0,0 +1,336 @@
+package gobin
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var (
+	ErrInvalidDiffVersion = errors.New("invalid version, must be a number")
+	ErrEncryptedDiff      = errors.New("encrypted documents can't be diffed by the server")
+	ErrDiffTooLarge       = errors.New("documents are too large to be diffed")
+)
+
+type (
+	DiffResponse struct {
+		From        DocumentResponse `json:"from"`
+		To          DocumentResponse `json:"to"`
+		Data        string           `json:"data"`
+		Formatted   template.HTML    `json:"formatted,omitempty"`
+		CSS         template.CSS     `json:"css,omitempty"`
+		RenderError string           `json:"render_error,omitempty"`
+	}
+	DiffTemplateVariables struct {
+		From      Document
+		To        Document
+		FromLabel string
+		ToLabel   string
+		Lines     []DiffLine
+		Additions int
+		Deletions int
+		Theme     string
+		Host      string
+	}
+	DiffLine struct {
+		Type        string
+		FromNumber  int
+		From        string
+		ToNumber    int
+		To          string
+		FromChanged bool
+		ToChanged   bool
+	}
+)
+
+func (s *Server) GetDocumentDiff(w http.ResponseWriter, r *http.Request) {
+	from, to, status, err := s.getDiffDocuments(r)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			s.log(r, "get document diff", err)
+		}
+		s.error(w, r, err, status)
+		return
+	}
+
+	diff, err := unifiedDiff(from, to)
+	if err != nil {
+		s.log(r, "create document diff", err)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var rendered renderedDocument
+	formatter := r.URL.Query().Get("formatter")
+	if formatter != "" {
+		rendered, err = s.renderDocument(r, Document{
+			ID:       from.ID,
+			Content:  diff,
+			Language: "diff",
+		}, formatter)
+		if err != nil {
+			s.log(r, "render document diff", err)
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.ok(w, r, DiffResponse{
+		From: DocumentResponse{
+			Key:       from.ID,
+			Version:   from.Version,
+			CreatedAt: from.CreatedAt,
+			Language:  from.Language,
+		},
+		To: DocumentResponse{
+			Key:       to.ID,
+			Version:   to.Version,
+			CreatedAt: to.CreatedAt,
+			Language:  to.Language,
+		},
+		Data:        diff,
+		Formatted:   rendered.HTML,
+		CSS:         rendered.CSS,
+		RenderError: rendered.renderError(),
+	})
+}
+
+func (s *Server) GetPrettyDocumentDiff(w http.ResponseWriter, r *http.Request) {
+	from, to, status, err := s.getDiffDocuments(r)
+	if err != nil {
+		if status == http.StatusNotFound {
+			s.redirectRoot(w, r)
+			return
+		}
+		s.prettyError(w, r, err, status)
+		return
+	}
+
+	lines, additions, deletions := sideBySideDiff(from.Content, to.Content)
+
+	theme := "dark"
+	if themeCookie, err := r.Cookie("theme"); err == nil && themeCookie.Value != "" {
+		theme = themeCookie.Value
+	}
+
+	vars := DiffTemplateVariables{
+		From:      from,
+		To:        to,
+		FromLabel: diffLabel(from),
+		ToLabel:   diffLabel(to),
+		Lines:     lines,
+		Additions: additions,
+		Deletions: deletions,
+		Theme:     theme,
+		Host:      r.Host,
+	}
+	if err = s.tmpl(w, "diff.gohtml", vars); err != nil {
+		s.log(r, "template", err)
+	}
+}
+
+// getDiffDocuments resolves the documents to diff from the request.
+// The from and to query parameters are versions, from defaults to the version before to and to defaults to the latest version.
+// If the to_document query parameter is set, to refers to a version of this document instead.
+func (s *Server) getDiffDocuments(r *http.Request) (Document, Document, int, error) {
+	documentID := chi.URLParam(r, "documentID")
+	query := r.URL.Query()
+
+	fromVersion, err := parseDiffVersion(query.Get("from"))
+	if err != nil {
+		return Document{}, Document{}, http.StatusBadRequest, err
+	}
+	toVersion, err := parseDiffVersion(query.Get("to"))
+	if err != nil {
+		return Document{}, Document{}, http.StatusBadRequest, err
+	}
+	toDocumentID := query.Get("to_document")
+	if toDocumentID == "" {
+		toDocumentID = documentID
+	}
+
+	to, err := s.getDiffDocument(r, toDocumentID, toVersion)
+	if err != nil {
+		return Document{}, Document{}, diffErrorStatus(err), err
+	}
+
+	var from Document
+	if fromVersion != 0 || toDocumentID != documentID {
+		from, err = s.getDiffDocument(r, documentID, fromVersion)
+		if err != nil {
+			return Document{}, Document{}, diffErrorStatus(err), err
+		}
+	} else {
+		// diff against the previous version, documents with a single version are diffed against nothing
+		from = Document{
+			ID:       documentID,
+			Language: to.Language,
+		}
+		versions, err := s.db.GetDocumentVersions(r.Context(), documentID, false)
+		if err != nil {
+			return Document{}, Document{}, http.StatusInternalServerError, err
+		}
+		for _, version := range versions {
+			if version.Version < to.Version {
+				from, err = s.db.GetDocumentVersion(r.Context(), documentID, version.Version)
+				if err != nil {
+					return Document{}, Document{}, diffErrorStatus(err), err
+				}
+				break
+			}
+		}
+	}
+
+	// matching the lines of large documents can take very long, so they are rejected before views are counted
+	if s.cfg.Render.MaxSize > 0 && len(from.Content)+len(to.Content) > s.cfg.Render.MaxSize {
+		return Document{}, Document{}, http.StatusUnprocessableEntity, ErrDiffTooLarge
+	}
+
+	if err = s.viewDocument(r, &to); err != nil {
+		return Document{}, Document{}, diffErrorStatus(err), err
+	}
+	if from.ID != to.ID && from.Version != 0 {
+		if err = s.viewDocument(r, &from); err != nil {
+			return Document{}, Document{}, diffErrorStatus(err), err
+		}
+	}
+	return from, to, http.StatusOK, nil
+}
+
+func (s *Server) getDiffDocument(r *http.Request, documentID string, version int64) (Document, error) {
+	var (
+		document Document
+		err      error
+	)
+	if version == 0 {
+		document, err = s.db.GetDocument(r.Context(), documentID)
+	} else {
+		document, err = s.db.GetDocumentVersion(r.Context(), documentID, version)
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	if !s.canReadDocument(r, document) {
+		return Document{}, sql.ErrNoRows
+	}
+	if document.Encrypted {
+		return Document{}, ErrEncryptedDiff
+	}
+	if err = s.checkDocumentPassword(r, document); err != nil {
+		return Document{}, err
+	}
+	return document, nil
+}
+
+func parseDiffVersion(version string) (int64, error) {
+	if version == "" {
+		return 0, nil
+	}
+	int64Version, err := strconv.ParseInt(version, 10, 64)
+	if err != nil || int64Version < 0 {
+		return 0, ErrInvalidDiffVersion
+	}
+	return int64Version, nil
+}
+
+func diffErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPasswordRequired), errors.Is(err, ErrInvalidPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrEncryptedDiff):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func diffLabel(document Document) string {
+	if document.Version == 0 {
+		return fmt.Sprintf("%s (empty)", document.ID)
+	}
+	timeStr := time.Unix(document.CreatedAt, 0).Format("02/01/2006 15:04:05")
+	return fmt.Sprintf("%s/%d (%s)", document.ID, document.Version, timeStr)
+}
+
+func unifiedDiff(from Document, to Document) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        unifiedDiffLines(from.Content),
+		B:        unifiedDiffLines(to.Content),
+		FromFile: fmt.Sprintf("%s/%d", from.ID, from.Version),
+		ToFile:   fmt.Sprintf("%s/%d", to.ID, to.Version),
+		Context:  3,
+	})
+}
+
+// sideBySideDiff returns the lines of both contents next to each other with the changed lines marked.
+func sideBySideDiff(from string, to string) ([]DiffLine, int, int) {
+	fromLines := splitDiffLines(from)
+	toLines := splitDiffLines(to)
+
+	var (
+		lines     []DiffLine
+		additions int
+		deletions int
+	)
+	for _, opCode := range difflib.NewMatcher(fromLines, toLines).GetOpCodes() {
+		switch opCode.Tag {
+		case 'e':
+			for i := 0; i < opCode.I2-opCode.I1; i++ {
+				lines = append(lines, DiffLine{
+					Type:       "equal",
+					FromNumber: opCode.I1 + i + 1,
+					From:       fromLines[opCode.I1+i],
+					ToNumber:   opCode.J1 + i + 1,
+					To:         toLines[opCode.J1+i],
+				})
+			}
+		default:
+			fromCount := opCode.I2 - opCode.I1
+			toCount := opCode.J2 - opCode.J1
+			deletions += fromCount
+			additions += toCount
+			for i := 0; i < fromCount || i < toCount; i++ {
+				line := DiffLine{
+					Type: "change",
+				}
+				if i < fromCount {
+					line.FromNumber = opCode.I1 + i + 1
+					line.From = fromLines[opCode.I1+i]
+					line.FromChanged = true
+				}
+				if i < toCount {
+					line.ToNumber = opCode.J1 + i + 1
+					line.To = toLines[opCode.J1+i]
+					line.ToChanged = true
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines, additions, deletions
+}
+
+func unifiedDiffLines(content string) []string {
+	lines := splitDiffLines(content)
+	for i := range lines {
+		lines[i] += "\n"
+	}
+	return lines
+}
+
+func splitDiffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
@@ -0,0 +1,61 @@
+package gobin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDiffTooLarge(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB(DatabaseConfig{CleanupInterval: time.Hour}, nil)
+	defer db.Close()
+	s := &Server{
+		cfg: Config{Render: RenderConfig{MaxSize: 1000}},
+		db:  db,
+	}
+
+	document, err := db.CreateDocument(ctx, Document{Content: strings.Repeat("a\n", 200), Language: "plaintext", ViewsLeft: ptr(int64(2))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		content string
+		want    error
+	}{
+		{strings.Repeat("b\n", 200), nil},
+		{strings.Repeat("b\n", 400), ErrDiffTooLarge},
+	} {
+		if _, err = db.UpdateDocument(ctx, document.ID, c.content, "plaintext", 0, DocumentUpdate{}); err != nil {
+			t.Fatal(err)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("documentID", document.ID)
+		r := httptest.NewRequest(http.MethodGet, "/documents/"+document.ID+"/diff", nil)
+		r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), ClaimsKey, &Claims{}))
+
+		_, _, _, err = s.getDiffDocuments(r)
+		if !errors.Is(err, c.want) {
+			t.Fatalf("expected %v for %d bytes, got %v", c.want, len(c.content), err)
+		}
+	}
+
+	// the rejected diff didn't count as view
+	latest, err := db.GetDocument(ctx, document.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *latest.ViewsLeft != 1 {
+		t.Fatalf("expected 1 view left, got %d", *latest.ViewsLeft)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,201 @@
+package gobin
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// encryptionKeySize is the size of the AES-256 key encryption keys and the data keys of every content.
+	encryptionKeySize = 32
+	// encryptedDataKeySize is the size of the nonce & sealed data key every encrypted content starts with.
+	encryptedDataKeySize = 12 + encryptionKeySize + 16
+)
+
+var (
+	ErrUnknownEncryptionKey    = errors.New("unknown encryption key")
+	ErrInvalidEncryptedContent = errors.New("invalid encrypted content")
+)
+
+// newKeyring creates the keyring of the configured encryption keys.
+// It returns nil if no keys are configured, which stores content unencrypted.
+func newKeyring(cfg EncryptionConfig) (*keyring, error) {
+	encodedKeys := make(map[string]string, len(cfg.Keys))
+	for id, key := range cfg.Keys {
+		encodedKeys[strings.ToLower(id)] = key
+	}
+	if cfg.KeyFile != "" {
+		data, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		var fileKeys map[string]string
+		if err = json.Unmarshal(data, &fileKeys); err != nil {
+			return nil, fmt.Errorf("failed to parse encryption key file: %w", err)
+		}
+		for id, key := range fileKeys {
+			// viper lowercases the key ids of the config, so they are case-insensitive everywhere
+			id = strings.ToLower(id)
+			if existing, ok := encodedKeys[id]; ok && existing != key {
+				return nil, fmt.Errorf("encryption key %q is configured twice with different keys", id)
+			}
+			encodedKeys[id] = key
+		}
+	}
+
+	keyID := strings.ToLower(cfg.KeyID)
+	if len(encodedKeys) == 0 {
+		if keyID != "" {
+			return nil, fmt.Errorf("encryption key %q is not configured", keyID)
+		}
+		return nil, nil
+	}
+
+	k := &keyring{
+		keyID: keyID,
+		keys:  make(map[string]cipher.AEAD, len(encodedKeys)),
+	}
+	for id, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d base64 encoded bytes", id, encryptionKeySize)
+		}
+		if k.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	if keyID != "" && k.keys[keyID] == nil {
+		return nil, fmt.Errorf("encryption key %q is not configured", keyID)
+	}
+	return k, nil
+}
+
+// keyring holds the key encryption keys by their id.
+// Every content is encrypted with its own random data key, which is encrypted with the key of keyID and stored in front of the content.
+// Keys can be rotated by changing keyID, contents encrypted with other keys stay readable as long as their key is in the keyring.
+type keyring struct {
+	// keyID is the id of the key new contents are encrypted with, contents are stored unencrypted if it's empty
+	keyID string
+	keys  map[string]cipher.AEAD
+}
+
+// encrypt encrypts the data of the content with a new data key and returns the id of the key encrypting the data key.
+// The hash of the content is authenticated, so the encrypted data can't be swapped between contents.
+func (k *keyring) encrypt(hash string, data []byte) (string, []byte, error) {
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", nil, err
+	}
+	encryptedDataKey, err := seal(k.keys[k.keyID], dataKey, []byte(k.keyID))
+	if err != nil {
+		return "", nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	encrypted, err := seal(dataAEAD, data, []byte(hash))
+	if err != nil {
+		return "", nil, err
+	}
+	return k.keyID, append(encryptedDataKey, encrypted...), nil
+}
+
+// decrypt decrypts the data of the content encrypted by encrypt with the key of keyID.
+func (k *keyring) decrypt(keyID string, hash string, data []byte) ([]byte, error) {
+	keyAEAD, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, keyID)
+	}
+	if len(data) < encryptedDataKeySize {
+		return nil, ErrInvalidEncryptedContent
+	}
+	dataKey, err := open(keyAEAD, data[:encryptedDataKeySize], []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataAEAD, data[encryptedDataKeySize:], []byte(hash))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, which is prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts the ciphertext created by seal.
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrInvalidEncryptedContent
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrInvalidEncryptedContent
+	}
+	return plaintext, nil
+}
+
+// startReencryption periodically re-encrypts contents which aren't encrypted with the configured key until it's stopped.
+// The first run starts after one interval, so restarts don't compete with the requests of a starting instance.
+func startReencryption(db *DB, interval time.Duration) *cleanupLoop {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cleanupLoop{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		defer close(c.done)
+		log.Println("Starting content re-encryption...")
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer log.Println("content re-encryption stopped")
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			rows, err := db.ReencryptContents(ctx)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.Println("failed to re-encrypt document contents:", err)
+			}
+			if rows > 0 {
+				log.Printf("Re-encrypted %d document contents", rows)
+			}
+		}
+	}()
+	return c
+}
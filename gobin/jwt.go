@@ -20,18 +20,25 @@ var (
 	ErrPermissionDenied = func(p Permission) error {
 		return fmt.Errorf("permission denied: %s", p)
 	}
+	ErrTokenExpired       = errors.New("token expired")
+	ErrTokenRevoked       = errors.New("token revoked")
+	ErrInvalidTTL         = errors.New("invalid ttl, must be a positive duration")
+	ErrNoRevokeToken      = errors.New("no token to revoke provided")
+	ErrInvalidRevokeToken = errors.New("invalid token to revoke")
+	ErrRevokeTokenNoID    = errors.New("token has no id and can only be revoked by revoking all tokens")
 )
 
 type Permission string
 
 const (
+	PermissionRead   Permission = "read"
 	PermissionWrite  Permission = "write"
 	PermissionDelete Permission = "delete"
 	PermissionShare  Permission = "share"
 )
 
 func (p Permission) IsValid() bool {
-	return p == PermissionWrite || p == PermissionDelete || p == PermissionShare
+	return p == PermissionRead || p == PermissionWrite || p == PermissionDelete || p == PermissionShare
 }
 
 type Claims struct {
@@ -50,16 +57,27 @@ func (s *Server) JWTMiddleware(next http.Handler) http.Handler {
 		var claims Claims
 		if tokenString == "" {
 			documentID := chi.URLParam(r, "documentID")
-			claims = newClaims(documentID, nil)
+			claims = newClaims(documentID, nil, nil)
 		} else {
-			token, err := jwt.ParseSigned(tokenString)
-			if err != nil {
+			var err error
+			if claims, err = s.parseToken(tokenString); err != nil {
 				s.error(w, r, err, http.StatusUnauthorized)
 				return
 			}
 
-			if err = token.Claims([]byte(s.cfg.JWTSecret), &claims); err != nil {
-				s.error(w, r, err, http.StatusUnauthorized)
+			if claims.Expiry != nil && time.Now().After(claims.Expiry.Time()) {
+				s.error(w, r, ErrTokenExpired, http.StatusUnauthorized)
+				return
+			}
+
+			revoked, err := s.db.IsTokenRevoked(r.Context(), claims.Subject, claims.ID, claims.IssuedAt.Time().Unix())
+			if err != nil {
+				s.log(r, "check token revocation", err)
+				s.error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				s.error(w, r, ErrTokenRevoked, http.StatusUnauthorized)
 				return
 			}
 		}
@@ -73,19 +91,40 @@ func (s *Server) GetClaims(r *http.Request) *Claims {
 	return r.Context().Value(ClaimsKey).(*Claims)
 }
 
-func (s *Server) NewToken(documentID string, permissions []Permission) (string, error) {
-	claims := newClaims(documentID, permissions)
+// NewToken creates a new token for the document with the given permissions.
+// The token never expires if expiresAt is nil.
+func (s *Server) NewToken(documentID string, permissions []Permission, expiresAt *time.Time) (string, error) {
+	return s.signClaims(newClaims(documentID, permissions, expiresAt))
+}
+
+func (s *Server) signClaims(claims Claims) (string, error) {
 	return jwt.Signed(s.signer).Claims(claims).CompactSerialize()
 }
 
-func newClaims(documentID string, permissions []Permission) Claims {
-	return Claims{
+func newClaims(documentID string, permissions []Permission, expiresAt *time.Time) Claims {
+	claims := Claims{
 		Claims: jwt.Claims{
+			ID:       randomString(16),
 			IssuedAt: jwt.NewNumericDate(time.Now()),
 			Subject:  documentID,
 		},
 		Permissions: permissions,
 	}
+	if expiresAt != nil {
+		claims.Expiry = jwt.NewNumericDate(*expiresAt)
+	}
+	return claims
+}
+
+// parseToken parses and verifies a token without checking its expiry or revocation.
+func (s *Server) parseToken(tokenString string) (Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseSigned(tokenString)
+	if err != nil {
+		return claims, err
+	}
+	err = token.Claims([]byte(s.cfg.JWTSecret), &claims)
+	return claims, err
 }
 
 func TokenFromHeader(r *http.Request) string {
@@ -0,0 +1,352 @@
+package gobin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// NewMemoryDB creates a Store which keeps everything in memory and starts its cleanup.
+// All documents are lost once the process exits, which makes it useful for tests and ephemeral instances.
+func NewMemoryDB(cfg DatabaseConfig, metrics *Metrics) *MemoryDB {
+	db := &MemoryDB{
+		documents:    map[string][]Document{},
+		lastVersions: map[string]int64{},
+		revoked:      map[string]map[string]memoryRevocation{},
+	}
+	db.cleanup = startCleanup(db, cfg, metrics)
+	return db
+}
+
+// MemoryDB is the Store backed by maps, it's safe for concurrent use.
+type MemoryDB struct {
+	mu sync.RWMutex
+	// documents holds the versions of each document ordered from oldest to newest
+	documents map[string][]Document
+	// lastVersions holds the last version of each document, so versions are never reused even if the latest version is deleted
+	lastVersions map[string]int64
+	// revoked holds the token revocations by document and token id
+	revoked   map[string]map[string]memoryRevocation
+	cleanup   *cleanupLoop
+	closeOnce sync.Once
+}
+
+type memoryRevocation struct {
+	revokedAt int64
+	expiresAt *int64
+}
+
+func (d *MemoryDB) Close() error {
+	d.closeOnce.Do(d.cleanup.stop)
+	return nil
+}
+
+func (d *MemoryDB) GetDocument(_ context.Context, documentID string) (Document, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	versions := d.documents[documentID]
+	if len(versions) == 0 || expired(versions[len(versions)-1], time.Now()) {
+		return Document{}, sql.ErrNoRows
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (d *MemoryDB) GetDocumentVersion(_ context.Context, documentID string, version int64) (Document, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	versions := d.documents[documentID]
+	i := versionIndex(versions, version)
+	if i == -1 || expired(versions[i], time.Now()) {
+		return Document{}, sql.ErrNoRows
+	}
+	return versions[i], nil
+}
+
+func (d *MemoryDB) GetDocumentVersions(_ context.Context, documentID string, withContent bool) ([]Document, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	versions := d.documents[documentID]
+	now := time.Now()
+	docs := make([]Document, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		doc := versions[i]
+		if expired(doc, now) {
+			continue
+		}
+		if !withContent {
+			doc.Content = ""
+			doc.Language = ""
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (d *MemoryDB) GetVersionCount(_ context.Context, documentID string) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.documents[documentID]), nil
+}
+
+func (d *MemoryDB) CreateDocument(_ context.Context, document Document) (Document, error) {
+	hash := contentHash(document.Content)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for try := 0; try < 10; try++ {
+		doc := document
+		doc.ID = randomString(8)
+		if _, ok := d.documents[doc.ID]; ok {
+			continue
+		}
+		doc.Version = 1
+		doc.CreatedAt = time.Now().Unix()
+		doc.LegacyVersion = nil
+		doc.ContentHash = hash
+		d.documents[doc.ID] = []Document{doc}
+		d.lastVersions[doc.ID] = doc.Version
+		return doc, nil
+	}
+	return Document{}, errors.New("failed to create document because of duplicate key after 10 tries")
+}
+
+func (d *MemoryDB) UpdateDocument(_ context.Context, documentID string, content string, language string, matchVersion int64, update DocumentUpdate) (Document, error) {
+	hash := contentHash(content)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	versions := d.documents[documentID]
+	if len(versions) == 0 {
+		return Document{}, sql.ErrNoRows
+	}
+	// the new version inherits the expiration, views, password & encryption of the latest version
+	doc := versions[len(versions)-1]
+	if matchVersion != 0 && doc.Version != matchVersion {
+		return Document{}, ErrVersionConflict
+	}
+	for i := range versions {
+		update.apply(&versions[i])
+	}
+	update.apply(&doc)
+	d.lastVersions[documentID]++
+	doc.Version = d.lastVersions[documentID]
+	doc.CreatedAt = time.Now().Unix()
+	doc.LegacyVersion = nil
+	doc.Content = content
+	doc.ContentHash = hash
+	doc.Language = language
+	d.documents[documentID] = append(versions, doc)
+	return doc, nil
+}
+
+func (d *MemoryDB) UpdateDocumentExpiration(_ context.Context, documentID string, expiresAt *int64) error {
+	return d.updateDocument(documentID, func(doc *Document) {
+		doc.ExpiresAt = expiresAt
+	})
+}
+
+func (d *MemoryDB) UpdateDocumentPassword(_ context.Context, documentID string, passwordHash *string) error {
+	return d.updateDocument(documentID, func(doc *Document) {
+		doc.PasswordHash = passwordHash
+	})
+}
+
+func (d *MemoryDB) UpdateDocumentVisibility(_ context.Context, documentID string, private bool, public bool) error {
+	return d.updateDocument(documentID, func(doc *Document) {
+		doc.Private = private
+		doc.Public = public
+	})
+}
+
+// updateDocument updates the metadata of all versions of a document.
+func (d *MemoryDB) updateDocument(documentID string, update func(doc *Document)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	versions := d.documents[documentID]
+	if len(versions) == 0 {
+		return sql.ErrNoRows
+	}
+	for i := range versions {
+		update(&versions[i])
+	}
+	return nil
+}
+
+func (d *MemoryDB) ViewDocument(_ context.Context, documentID string) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var (
+		viewed    bool
+		viewsLeft int64
+	)
+	versions := d.documents[documentID]
+	for i := range versions {
+		if versions[i].ViewsLeft == nil || *versions[i].ViewsLeft <= 0 {
+			continue
+		}
+		// replace the pointer instead of decrementing it, documents returned earlier share it
+		left := *versions[i].ViewsLeft - 1
+		versions[i].ViewsLeft = &left
+		if !viewed {
+			viewed = true
+			viewsLeft = left
+		}
+	}
+	if !viewed {
+		return 0, sql.ErrNoRows
+	}
+	if viewsLeft <= 0 {
+		d.deleteDocument(documentID)
+	}
+	return viewsLeft, nil
+}
+
+func (d *MemoryDB) DeleteDocument(_ context.Context, documentID string, matchVersion int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	versions, ok := d.documents[documentID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if matchVersion != 0 && versions[len(versions)-1].Version != matchVersion {
+		return ErrVersionConflict
+	}
+	d.deleteDocument(documentID)
+	return nil
+}
+
+func (d *MemoryDB) DeleteDocumentByVersion(_ context.Context, documentID string, version int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	versions := d.documents[documentID]
+	i := versionIndex(versions, version)
+	if i == -1 {
+		return sql.ErrNoRows
+	}
+	versions = slices.Delete(versions, i, i+1)
+	if len(versions) == 0 {
+		d.deleteDocument(documentID)
+		return nil
+	}
+	d.documents[documentID] = versions
+	return nil
+}
+
+// deleteDocument deletes a document with all its versions and its version sequence, the lock has to be held.
+func (d *MemoryDB) deleteDocument(documentID string) {
+	delete(d.documents, documentID)
+	delete(d.lastVersions, documentID)
+}
+
+// SearchDocuments matches documents containing all terms of the query case-insensitively.
+// Unlike the SQL databases results are not ranked by relevance but ordered by creation time.
+func (d *MemoryDB) SearchDocuments(_ context.Context, opts SearchOptions) ([]SearchResult, error) {
+	terms := searchTerms(opts.Query)
+	now := time.Now()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var results []SearchResult
+	for _, versions := range d.documents {
+		if expired(versions[len(versions)-1], now) {
+			continue
+		}
+		if result, ok := searchDocument(versions[len(versions)-1], opts, terms); ok {
+			results = append(results, result)
+		}
+	}
+	return limitSearchResults(results, opts.Limit), nil
+}
+
+func (d *MemoryDB) RevokeToken(_ context.Context, documentID string, tokenID string, expiresAt *int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	revoked, ok := d.revoked[documentID]
+	if !ok {
+		revoked = map[string]memoryRevocation{}
+		d.revoked[documentID] = revoked
+	}
+	if _, ok = revoked[tokenID]; !ok {
+		revoked[tokenID] = memoryRevocation{
+			revokedAt: time.Now().Unix(),
+			expiresAt: expiresAt,
+		}
+	}
+	return nil
+}
+
+func (d *MemoryDB) RevokeAllTokens(_ context.Context, documentID string, revokedAt int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	revoked, ok := d.revoked[documentID]
+	if !ok {
+		revoked = map[string]memoryRevocation{}
+		d.revoked[documentID] = revoked
+	}
+	revoked[allTokensID] = memoryRevocation{
+		revokedAt: revokedAt,
+	}
+	return nil
+}
+
+func (d *MemoryDB) IsTokenRevoked(_ context.Context, documentID string, tokenID string, issuedAt int64) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	revoked := d.revoked[documentID]
+	if _, ok := revoked[tokenID]; ok {
+		return true, nil
+	}
+	all, ok := revoked[allTokensID]
+	return ok && all.revokedAt > issuedAt, nil
+}
+
+func (d *MemoryDB) DeleteExpiredDocuments(_ context.Context, expireAfter time.Duration) (int64, error) {
+	now := time.Now()
+	var minCreatedAt int64
+	if expireAfter > 0 {
+		minCreatedAt = now.Add(-expireAfter).Unix()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var deleted int64
+	for id, versions := range d.documents {
+		kept := versions[:0]
+		for _, doc := range versions {
+			if (doc.ExpiresAt != nil && *doc.ExpiresAt <= now.Unix()) || (doc.ExpiresAt == nil && doc.CreatedAt < minCreatedAt) {
+				deleted++
+				continue
+			}
+			kept = append(kept, doc)
+		}
+		if len(kept) == 0 {
+			d.deleteDocument(id)
+			continue
+		}
+		d.documents[id] = kept
+	}
+	return deleted, nil
+}
+
+func (d *MemoryDB) DeleteExpiredTokenRevocations(_ context.Context) (int64, error) {
+	now := time.Now().Unix()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var deleted int64
+	for documentID, revoked := range d.revoked {
+		_, exists := d.documents[documentID]
+		for tokenID, revocation := range revoked {
+			if !exists || (revocation.expiresAt != nil && *revocation.expiresAt <= now) {
+				delete(revoked, tokenID)
+				deleted++
+			}
+		}
+		if len(revoked) == 0 {
+			delete(d.revoked, documentID)
+		}
+	}
+	return deleted, nil
+}
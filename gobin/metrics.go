@@ -0,0 +1,200 @@
+package gobin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "gobin"
+
+// NewMetrics creates and registers all metrics, it returns nil if metrics are disabled.
+// All methods of Metrics are safe to call on nil.
+func NewMetrics(cfg MetricsConfig) *Metrics {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		documents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "documents_total",
+			Help:      "Total number of created, updated and deleted documents.",
+		}, []string{"action"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Total number of requests rejected by the rate limit.",
+		}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_duration_seconds",
+			Help:      "Duration of rendering documents by formatter.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"formatter"}),
+		renderFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_failures_total",
+			Help:      "Total number of documents which couldn't be rendered by formatter and reason.",
+		}, []string{"formatter", "reason"}),
+		renderCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "render_cache_lookups_total",
+			Help:      "Total number of rendered document lookups by cache and result.",
+		}, []string{"cache", "result"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Duration of database queries by query.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"query"}),
+		cleanupDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cleanup_deleted_rows_total",
+			Help:      "Total number of rows deleted by the cleanup by table.",
+		}, []string{"table"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.documents,
+		m.rateLimited,
+		m.renderDuration,
+		m.renderFailures,
+		m.renderCacheLookups,
+		m.queryDuration,
+		m.cleanupDeleted,
+	)
+	return m
+}
+
+type Metrics struct {
+	registry           *prometheus.Registry
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	documents          *prometheus.CounterVec
+	rateLimited        prometheus.Counter
+	renderDuration     *prometheus.HistogramVec
+	renderFailures     *prometheus.CounterVec
+	renderCacheLookups *prometheus.CounterVec
+	queryDuration      *prometheus.HistogramVec
+	cleanupDeleted     *prometheus.CounterVec
+}
+
+// Handler serves the metrics in the prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts requests and observes their duration by the chi route pattern, so document ids don't end up in labels.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+			route = routeCtx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *Metrics) DocumentCreated() {
+	if m == nil {
+		return
+	}
+	m.documents.WithLabelValues("create").Inc()
+}
+
+func (m *Metrics) DocumentUpdated() {
+	if m == nil {
+		return
+	}
+	m.documents.WithLabelValues("update").Inc()
+}
+
+func (m *Metrics) DocumentDeleted() {
+	if m == nil {
+		return
+	}
+	m.documents.WithLabelValues("delete").Inc()
+}
+
+func (m *Metrics) RateLimited() {
+	if m == nil {
+		return
+	}
+	m.rateLimited.Inc()
+}
+
+func (m *Metrics) ObserveRender(formatter string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.renderDuration.WithLabelValues(formatter).Observe(time.Since(start).Seconds())
+}
+
+// RenderFailed counts a document which couldn't be rendered, the reason is "too_large", "timeout" or "error".
+func (m *Metrics) RenderFailed(formatter string, reason string) {
+	if m == nil {
+		return
+	}
+	m.renderFailures.WithLabelValues(formatter, reason).Inc()
+}
+
+// RenderCacheLookup counts a lookup of a rendered document in the "local" or "external" cache.
+func (m *Metrics) RenderCacheLookup(cache string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.renderCacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// ObserveQuery observes the duration of a query since start, it's meant to be deferred.
+func (m *Metrics) ObserveQuery(query string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) CleanupDeleted(table string, rows int64) {
+	if m == nil {
+		return
+	}
+	m.cleanupDeleted.WithLabelValues(table).Add(float64(rows))
+}
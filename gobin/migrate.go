@@ -0,0 +1,350 @@
+package gobin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	schemaMigrationsSchema = `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    BIGINT  NOT NULL,
+    name       VARCHAR NOT NULL,
+    applied_at BIGINT  NOT NULL,
+    PRIMARY KEY (version)
+);`
+
+	// migrationLockID is the postgres advisory lock which stops multiple gobin instances from migrating at the same time.
+	migrationLockID = 7316240925
+)
+
+var ErrPendingMigrations = errors.New("database schema is not up to date, run gobin migrate up")
+
+// Migration changes the schema of a database, Down reverts the changes of Up.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a known or applied migration.
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is the unix time the migration was applied at, it's nil if the migration is pending
+	AppliedAt *int64
+	// Unknown is true if the migration was applied by a newer gobin version
+	Unknown bool
+}
+
+// LoadMigrations loads the migrations of the database type from the migrations directory, ordered by version.
+// Migrations are named <dbType>/<version>_<name>.up.sql and <dbType>/<version>_<name>.down.sql.
+func LoadMigrations(migrations fs.FS, dbType string) ([]Migration, error) {
+	files, err := fs.ReadDir(migrations, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, file := range files {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(file.Name(), ".sql"), ".")
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".sql") || !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name: %s", file.Name())
+		}
+		versionStr, name, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", file.Name())
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version: %s", file.Name())
+		}
+		data, err := fs.ReadFile(migrations, path.Join(dbType, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has multiple names: %s, %s", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	loaded := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down migration", migration.Version, migration.Name)
+		}
+		loaded = append(loaded, *migration)
+	}
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].Version < loaded[j].Version
+	})
+	return loaded, nil
+}
+
+// NewMigrator connects to the postgres or sqlite database to apply and revert migrations, it has to be closed after use.
+func NewMigrator(ctx context.Context, cfg DatabaseConfig, migrations fs.FS) (*Migrator, error) {
+	dbx, err := connectSQL(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMigrator(dbx, cfg.Type, migrations)
+	if err != nil {
+		_ = dbx.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMigrator(dbx *sqlx.DB, dbType string, migrations fs.FS) (*Migrator, error) {
+	loaded, err := LoadMigrations(migrations, dbType)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		dbx:        dbx,
+		dbType:     dbType,
+		migrations: loaded,
+	}, nil
+}
+
+// Migrator applies and reverts the migrations of a database, the applied migrations are tracked in the schema_migrations table.
+// Every migration runs in its own transaction.
+type Migrator struct {
+	dbx        *sqlx.DB
+	dbType     string
+	migrations []Migration
+}
+
+// Close closes the database connection of a Migrator created by NewMigrator.
+func (m *Migrator) Close() error {
+	return m.dbx.Close()
+}
+
+// Status returns all known and applied migrations ordered by version, including migrations applied by newer gobin versions.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.setup(ctx); err != nil {
+		return nil, err
+	}
+	return m.status(ctx)
+}
+
+// Check returns ErrPendingMigrations if not all migrations are applied.
+// It fails if the database has migrations applied which this gobin version doesn't know, as its schema may be incompatible.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.knownStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			return ErrPendingMigrations
+		}
+	}
+	return nil
+}
+
+// Up applies all pending migrations and returns the number of applied migrations.
+// It fails if the database has migrations applied which this gobin version doesn't know, as its schema may be incompatible.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	statuses, err := m.knownStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var applied int
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+		if err = m.apply(ctx, status.Migration, true); err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d_%s: %w", status.Version, status.Name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// Down reverts the given number of applied migrations, starting with the latest, and returns the number of reverted migrations.
+// It fails if the database has migrations applied which this gobin version doesn't know, as it can't revert them.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	statuses, err := m.knownStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var reverted int
+	for i := len(statuses) - 1; i >= 0 && reverted < steps; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		if err = m.apply(ctx, statuses[i].Migration, false); err != nil {
+			return reverted, fmt.Errorf("failed to revert migration %d_%s: %w", statuses[i].Version, statuses[i].Name, err)
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// setup creates the schema_migrations table.
+// Databases of the released gobin v1.3.0 schema, which was created before migrations were tracked, get its migration marked as applied.
+func (m *Migrator) setup(ctx context.Context) error {
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exists, err := m.tableExists(ctx, tx, "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	var legacyVersion int64
+	if exists, err = m.tableExists(ctx, tx, "documents"); err != nil {
+		return err
+	}
+	if exists {
+		// the schema of gobin v1.3.0
+		legacyVersion = 1
+	}
+
+	if _, err = tx.ExecContext(ctx, schemaMigrationsSchema); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, migration := range m.migrations {
+		if migration.Version > legacyVersion {
+			break
+		}
+		if _, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", migration.Version, migration.Name, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) status(ctx context.Context) ([]MigrationStatus, error) {
+	var applied []struct {
+		Version   int64  `db:"version"`
+		Name      string `db:"name"`
+		AppliedAt int64  `db:"applied_at"`
+	}
+	if err := m.dbx.SelectContext(ctx, &applied, "SELECT version, name, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	known := make(map[int64]int, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses = append(statuses, MigrationStatus{Migration: migration})
+		known[migration.Version] = i
+	}
+	for _, migration := range applied {
+		appliedAt := migration.AppliedAt
+		if i, ok := known[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+			continue
+		}
+		statuses = append(statuses, MigrationStatus{
+			Migration: Migration{Version: migration.Version, Name: migration.Name},
+			AppliedAt: &appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// knownStatus returns the status of all migrations, if no unknown migrations are applied.
+func (m *Migrator) knownStatus(ctx context.Context) ([]MigrationStatus, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		if status.Unknown {
+			return nil, fmt.Errorf("database has migration %d_%s applied which is unknown to this gobin version, update gobin", status.Version, status.Name)
+		}
+	}
+	return statuses, nil
+}
+
+// apply applies or reverts the migration, unless another gobin instance did it already.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) error {
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	if err = tx.GetContext(ctx, &applied, "SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)", migration.Version); err != nil {
+		return err
+	}
+	if applied == up {
+		return nil
+	}
+
+	if up {
+		if _, err = tx.ExecContext(ctx, migration.Up); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", migration.Version, migration.Name, time.Now().Unix())
+	} else {
+		if _, err = tx.ExecContext(ctx, migration.Down); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// begin starts a transaction which holds the migration lock on postgres until it ends.
+// sqlite only allows one writing transaction at a time anyway.
+func (m *Migrator) begin(ctx context.Context) (*sqlx.Tx, error) {
+	tx, err := m.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.dbType == "postgres" {
+		if _, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+func (m *Migrator) tableExists(ctx context.Context, tx *sqlx.Tx, table string) (bool, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = $1)"
+	if m.dbType == "postgres" {
+		query = "SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)"
+	}
+	var exists bool
+	err := tx.GetContext(ctx, &exists, query, table)
+	return exists, err
+}
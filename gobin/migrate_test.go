@@ -0,0 +1,42 @@
+package gobin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacySchema(t *testing.T) {
+	ctx := context.Background()
+	migrations := os.DirFS("../sql/migrations")
+	cfg := DatabaseConfig{
+		Type: "sqlite",
+		Path: filepath.Join(t.TempDir(), "gobin.db"),
+	}
+	loaded, err := LoadMigrations(migrations, cfg.Type)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema of gobin v1.3.0 without schema_migrations
+	migrator, err := NewMigrator(ctx, cfg, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+	if _, err = migrator.dbx.ExecContext(ctx, loaded[0].Up); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(loaded)-1 {
+		t.Fatalf("expected %d applied migrations, got %d", len(loaded)-1, applied)
+	}
+	if err = migrator.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package gobin
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// formatterContentTypes are the content types of the formatter outputs, formatters which aren't listed output plain text.
+var formatterContentTypes = map[string]string{
+	"html":            "text/html; charset=UTF-8",
+	"html-standalone": "text/html; charset=UTF-8",
+	"svg":             "image/svg+xml; charset=UTF-8",
+	"json":            "application/json; charset=UTF-8",
+}
+
+// formatterExtensions are the file extensions of downloaded formatter outputs, formatters which aren't listed output .txt files.
+var formatterExtensions = map[string]string{
+	"html":            ".html",
+	"html-standalone": ".html",
+	"svg":             ".svg",
+	"json":            ".json",
+}
+
+// activeContentTypes are content types browsers could run scripts of, documents of these languages are always served as plain text.
+var activeContentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"image/svg+xml",
+	"text/xml",
+	"application/xml",
+}
+
+// rawContentType returns the content type of a raw document.
+// Formatted documents use the type of the formatter output, unformatted documents the type of their language if languageTypes is set.
+func rawContentType(document Document, formatter string, languageTypes bool) string {
+	if formatter != "" {
+		if contentType, ok := formatterContentTypes[formatter]; ok {
+			return contentType
+		}
+		return "text/plain; charset=UTF-8"
+	}
+	if !languageTypes || document.Encrypted {
+		return "text/plain; charset=UTF-8"
+	}
+	lexer := lexers.Get(document.Language)
+	if lexer == nil || len(lexer.Config().MimeTypes) == 0 {
+		return "text/plain; charset=UTF-8"
+	}
+	mediaType := lexer.Config().MimeTypes[0]
+	for _, activeType := range activeContentTypes {
+		if mediaType == activeType {
+			return "text/plain; charset=UTF-8"
+		}
+	}
+	return mediaType + "; charset=UTF-8"
+}
+
+// rawFilename returns the filename of a downloaded raw document, the document ID with the extension of the formatter output or the language.
+func rawFilename(document Document, formatter string) string {
+	if formatter != "" {
+		if extension, ok := formatterExtensions[formatter]; ok {
+			return document.ID + extension
+		}
+		return document.ID + ".txt"
+	}
+	if document.Encrypted {
+		return document.ID + ".txt"
+	}
+	lexer := lexers.Get(document.Language)
+	if lexer == nil {
+		return document.ID + ".txt"
+	}
+	for _, pattern := range lexer.Config().Filenames {
+		// skip filenames like Dockerfile and globs like *.x[bp]m
+		extension, ok := strings.CutPrefix(pattern, "*")
+		if ok && strings.HasPrefix(extension, ".") && !strings.ContainsAny(extension, "*?[]") {
+			return document.ID + extension
+		}
+	}
+	return document.ID + ".txt"
+}
+
+// contentDisposition returns the Content-Disposition header which makes browsers download the response as the file.
+func contentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
@@ -0,0 +1,166 @@
+package gobin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+// renderCheckInterval is the number of tokens after which a render checks if its deadline has passed.
+const renderCheckInterval = 1024
+
+var (
+	ErrRenderTooLarge = errors.New("document is too large to be highlighted")
+	ErrRenderTimeout  = errors.New("document took too long to highlight")
+	ErrRendererClosed = errors.New("renderer closed")
+)
+
+// NewRenderer starts the workers which render documents, they run until Close is called.
+func NewRenderer(cfg RenderConfig) *Renderer {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	r := &Renderer{
+		cfg:  cfg,
+		jobs: make(chan renderJob),
+		done: make(chan struct{}),
+	}
+	r.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// Renderer lexes and formats documents on a bounded number of workers, so large or pathological documents can't use up all CPUs.
+// Renders are stopped once their deadline has passed, as chroma doesn't take a context.
+type Renderer struct {
+	cfg  RenderConfig
+	jobs chan renderJob
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+type renderJob struct {
+	ctx       context.Context
+	content   string
+	lexer     chroma.Lexer
+	formatter chroma.Formatter
+	style     *chroma.Style
+	result    chan<- renderResult
+}
+
+type renderResult struct {
+	formatted string
+	err       error
+}
+
+// Render highlights the content on a worker and returns the formatted content.
+// It returns ErrRenderTooLarge if the content exceeds the max size and ErrRenderTimeout if no worker finished it in time.
+func (r *Renderer) Render(ctx context.Context, content string, lexer chroma.Lexer, formatter chroma.Formatter, style *chroma.Style) (string, error) {
+	if r.cfg.MaxSize > 0 && len(content) > r.cfg.MaxSize {
+		return "", ErrRenderTooLarge
+	}
+	if r.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.Timeout)
+		defer cancel()
+	}
+
+	// the worker may still send its result after we stopped waiting for it
+	result := make(chan renderResult, 1)
+	select {
+	case r.jobs <- renderJob{
+		ctx:       ctx,
+		content:   content,
+		lexer:     lexer,
+		formatter: formatter,
+		style:     style,
+		result:    result,
+	}:
+	case <-ctx.Done():
+		return "", renderContextError(ctx)
+	case <-r.done:
+		return "", ErrRendererClosed
+	}
+
+	select {
+	case res := <-result:
+		return res.formatted, res.err
+	case <-ctx.Done():
+		return "", renderContextError(ctx)
+	}
+}
+
+// Close stops the workers, running renders are finished first.
+func (r *Renderer) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+}
+
+func (r *Renderer) work() {
+	defer r.wg.Done()
+	for {
+		select {
+		case job := <-r.jobs:
+			formatted, err := render(job)
+			job.result <- renderResult{
+				formatted: formatted,
+				err:       err,
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func render(job renderJob) (string, error) {
+	if err := job.ctx.Err(); err != nil {
+		return "", renderContextError(job.ctx)
+	}
+	iterator, err := job.lexer.Tokenise(nil, job.content)
+	if err != nil {
+		return "", err
+	}
+
+	var tokens int
+	buff := new(bytes.Buffer)
+	if err = job.formatter.Format(buff, job.style, func() chroma.Token {
+		// end the token stream early once nobody waits for the render anymore
+		tokens++
+		if tokens%renderCheckInterval == 0 && job.ctx.Err() != nil {
+			return chroma.EOF
+		}
+		return iterator()
+	}); err != nil {
+		return "", err
+	}
+	if job.ctx.Err() != nil {
+		return "", renderContextError(job.ctx)
+	}
+	return buff.String(), nil
+}
+
+// renderContextError returns ErrRenderTimeout if the deadline of the render has passed, or the error of the canceled context.
+func renderContextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrRenderTimeout
+	}
+	return ctx.Err()
+}
+
+// plaintextIterator returns the content as a single text token, which formatters can format without lexing it.
+func plaintextIterator(content string) chroma.Iterator {
+	return chroma.Literator(chroma.Token{
+		Type:  chroma.Text,
+		Value: content,
+	})
+}
@@ -0,0 +1,300 @@
+package gobin
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRenderCacheMiss is returned by a RenderStore if it has no rendered document for the key.
+var ErrRenderCacheMiss = errors.New("render cache miss")
+
+// RenderStore is an external cache of rendered documents, which is shared by all gobin instances.
+// Rendered documents are grouped by their document, so all renderings of a document can be deleted at once.
+type RenderStore interface {
+	// Get returns the rendered document of the key or ErrRenderCacheMiss.
+	Get(ctx context.Context, documentID string, key string) ([]byte, error)
+	Set(ctx context.Context, documentID string, key string, value []byte) error
+	// Delete deletes all rendered documents of a document.
+	Delete(ctx context.Context, documentID string) error
+	Close() error
+}
+
+// NewRenderStore creates the RenderStore of the configured render cache type.
+// It returns nil if rendered documents aren't shared between gobin instances.
+func NewRenderStore(cfg RenderCacheConfig) (RenderStore, error) {
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "redis":
+		return NewRedisRenderStore(cfg, ttl), nil
+	default:
+		return nil, errors.New("invalid render cache type, must be one of: redis")
+	}
+}
+
+// NewRedisRenderStore creates a RenderStore which keeps the rendered documents of every document in a redis hash.
+func NewRedisRenderStore(cfg RenderCacheConfig, ttl time.Duration) *RedisRenderStore {
+	return &RedisRenderStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     cfg.Address,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		}),
+		ttl: ttl,
+	}
+}
+
+type RedisRenderStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func (s *RedisRenderStore) Get(ctx context.Context, documentID string, key string) ([]byte, error) {
+	value, err := s.client.HGet(ctx, redisRenderKey(documentID), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrRenderCacheMiss
+	}
+	return value, err
+}
+
+// Set stores the rendered document, the TTL of the hash starts again with every rendered document of the document.
+func (s *RedisRenderStore) Set(ctx context.Context, documentID string, key string, value []byte) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisRenderKey(documentID), key, value)
+		pipe.Expire(ctx, redisRenderKey(documentID), s.ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisRenderStore) Delete(ctx context.Context, documentID string) error {
+	return s.client.Del(ctx, redisRenderKey(documentID)).Err()
+}
+
+func (s *RedisRenderStore) Close() error {
+	return s.client.Close()
+}
+
+func redisRenderKey(documentID string) string {
+	return "gobin:render:" + documentID
+}
+
+// renderKey identifies a rendered document version.
+// The content hash is part of it, as the ID and version of an expired or deleted document are given to a new document eventually.
+type renderKey struct {
+	documentID  string
+	version     int64
+	contentHash string
+	language    string
+	style       string
+	formatter   string
+}
+
+// String returns the key of the rendered document within its document, see RenderStore.
+func (k renderKey) String() string {
+	return strconv.FormatInt(k.version, 10) + "\x00" + k.contentHash + "\x00" + k.language + "\x00" + k.style + "\x00" + k.formatter
+}
+
+type renderedDocument struct {
+	HTML     template.HTML `json:"html"`
+	CSS      template.CSS  `json:"css"`
+	Language string        `json:"language"`
+	// Style is the name of the style the document was rendered with, it's part of the renderKey
+	Style string `json:"-"`
+	// Unhighlighted is ErrRenderTooLarge or ErrRenderTimeout if the document was rendered as plaintext, these are never cached
+	Unhighlighted error `json:"-"`
+}
+
+// renderError returns why the document was rendered as plaintext, or an empty string if it was highlighted.
+func (d renderedDocument) renderError() string {
+	if d.Unhighlighted == nil {
+		return ""
+	}
+	return d.Unhighlighted.Error()
+}
+
+// size returns the approximate memory used by the cached rendered document.
+func (d renderedDocument) size(key renderKey) int64 {
+	return int64(len(d.HTML) + len(d.CSS) + len(d.Language) + len(key.documentID) + len(key.contentHash) + len(key.language) + len(key.style) + len(key.formatter) + 128)
+}
+
+// NewRenderCache creates the cache of rendered documents, it returns nil if no cache is configured.
+// All methods of RenderCache are safe to call on nil.
+func NewRenderCache(cfg RenderCacheConfig, metrics *Metrics) (*RenderCache, error) {
+	store, err := NewRenderStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxSize <= 0 && store == nil {
+		return nil, nil
+	}
+	return newRenderCache(cfg.MaxSize, store, metrics), nil
+}
+
+func newRenderCache(maxSize int64, store RenderStore, metrics *Metrics) *RenderCache {
+	return &RenderCache{
+		maxSize:   maxSize,
+		entries:   map[renderKey]*list.Element{},
+		documents: map[string]map[renderKey]struct{}{},
+		lru:       list.New(),
+		store:     store,
+		metrics:   metrics,
+	}
+}
+
+// RenderCache caches rendered documents in-process, up to a memory budget, and in an optional RenderStore shared by all gobin instances.
+// The least recently used rendered documents are evicted first once the memory budget is exceeded.
+// Rendered documents are invalidated when their document is updated or deleted, rendered documents of expired documents are only evicted eventually.
+// They are never served for a new document with the same ID, as the key includes the content hash of the version.
+type RenderCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[renderKey]*list.Element
+	// documents holds the keys of the rendered documents by document, so they can be invalidated together
+	documents map[string]map[renderKey]struct{}
+	// lru holds the renderCacheEntry of every rendered document, the most recently used first
+	lru     *list.List
+	store   RenderStore
+	metrics *Metrics
+}
+
+type renderCacheEntry struct {
+	key      renderKey
+	document renderedDocument
+	size     int64
+}
+
+// get returns the rendered document from the in-process cache, or from the RenderStore which also caches it in-process.
+func (c *RenderCache) get(ctx context.Context, key renderKey) (renderedDocument, bool) {
+	if c == nil {
+		return renderedDocument{}, false
+	}
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if c.maxSize > 0 {
+		c.metrics.RenderCacheLookup("local", ok)
+	}
+	if ok {
+		return element.Value.(*renderCacheEntry).document, true
+	}
+	if c.store == nil {
+		return renderedDocument{}, false
+	}
+
+	value, err := c.store.Get(ctx, key.documentID, key.String())
+	if err != nil && !errors.Is(err, ErrRenderCacheMiss) {
+		log.Println("failed to get rendered document from render cache:", err)
+	}
+	var document renderedDocument
+	if err == nil {
+		if err = json.Unmarshal(value, &document); err != nil {
+			log.Println("failed to decode rendered document from render cache:", err)
+		}
+	}
+	c.metrics.RenderCacheLookup("external", err == nil)
+	if err != nil {
+		return renderedDocument{}, false
+	}
+	c.add(key, document)
+	return document, true
+}
+
+// set caches the rendered document in-process and in the RenderStore.
+func (c *RenderCache) set(ctx context.Context, key renderKey, document renderedDocument) {
+	if c == nil {
+		return
+	}
+	c.add(key, document)
+	if c.store == nil {
+		return
+	}
+	value, err := json.Marshal(document)
+	if err != nil {
+		log.Println("failed to encode rendered document for render cache:", err)
+		return
+	}
+	if err = c.store.Set(ctx, key.documentID, key.String(), value); err != nil {
+		log.Println("failed to store rendered document in render cache:", err)
+	}
+}
+
+// add caches the rendered document in-process and evicts the least recently used rendered documents which exceed the memory budget.
+func (c *RenderCache) add(key renderKey, document renderedDocument) {
+	size := document.size(key)
+	if size > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&renderCacheEntry{
+		key:      key,
+		document: document,
+		size:     size,
+	})
+	if c.documents[key.documentID] == nil {
+		c.documents[key.documentID] = map[renderKey]struct{}{}
+	}
+	c.documents[key.documentID][key] = struct{}{}
+	c.size += size
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the rendered document of the element from the in-process cache, c.mu has to be held.
+func (c *RenderCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*renderCacheEntry)
+	delete(c.entries, entry.key)
+	delete(c.documents[entry.key.documentID], entry.key)
+	if len(c.documents[entry.key.documentID]) == 0 {
+		delete(c.documents, entry.key.documentID)
+	}
+	c.size -= entry.size
+}
+
+// Invalidate removes all rendered versions of a document from the cache, it's called when a document is updated or deleted.
+func (c *RenderCache) Invalidate(ctx context.Context, documentID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	for key := range c.documents[documentID] {
+		c.remove(c.entries[key])
+	}
+	c.mu.Unlock()
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Delete(ctx, documentID); err != nil {
+		log.Println("failed to invalidate rendered documents in render cache:", err)
+	}
+}
+
+// Close closes the connection to the RenderStore.
+func (c *RenderCache) Close() error {
+	if c == nil || c.store == nil {
+		return nil
+	}
+	return c.store.Close()
+}
@@ -0,0 +1,65 @@
+package gobin
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestRenderCacheReusedID(t *testing.T) {
+	ctx := context.Background()
+	for _, cache := range []*RenderCache{
+		newRenderCache(1<<20, nil, nil),
+		newRenderCache(0, &memoryRenderStore{documents: map[string]map[string][]byte{}}, nil),
+	} {
+		key := renderKey{documentID: "hocwr6i6", version: 1, contentHash: "a", language: "Go", style: "monokai", formatter: "html"}
+		cache.set(ctx, key, renderedDocument{HTML: "expired"})
+
+		// the expired document is never invalidated, a new document gets its ID
+		reused := key
+		reused.contentHash = "b"
+		if rendered, ok := cache.get(ctx, reused); ok {
+			t.Errorf("expected no rendering of the new document, got %q", rendered.HTML)
+		}
+		if rendered, ok := cache.get(ctx, key); !ok || rendered.HTML != "expired" {
+			t.Errorf("expected the rendering of the expired document, got %q", rendered.HTML)
+		}
+	}
+}
+
+// memoryRenderStore is a RenderStore which stands in for redis in tests, it never expires rendered documents.
+type memoryRenderStore struct {
+	mu        sync.Mutex
+	documents map[string]map[string][]byte
+}
+
+func (s *memoryRenderStore) Get(_ context.Context, documentID string, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.documents[documentID][key]
+	if !ok {
+		return nil, ErrRenderCacheMiss
+	}
+	return value, nil
+}
+
+func (s *memoryRenderStore) Set(_ context.Context, documentID string, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.documents[documentID] == nil {
+		s.documents[documentID] = map[string][]byte{}
+	}
+	s.documents[documentID][key] = value
+	return nil
+}
+
+func (s *memoryRenderStore) Delete(_ context.Context, documentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.documents, documentID)
+	return nil
+}
+
+func (s *memoryRenderStore) Close() error {
+	return nil
+}
@@ -2,7 +2,9 @@ package gobin
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,18 +23,40 @@ import (
 	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/exp/slices"
 )
 
-const maxUnix = int(^int32(0))
+const (
+	maxUnix = int(^int32(0))
+
+	// PasswordHeader is the header used to set or provide the password of a document
+	PasswordHeader = "X-Password"
+
+	// IfMatchHeader is the header used to only update or delete a document if its current version matches the ETag, see VersionETag
+	IfMatchHeader = "If-Match"
+)
 
 var (
 	ErrDocumentNotFound = errors.New("document not found")
 	ErrRateLimit        = errors.New("rate limit exceeded")
 	ErrEmptyBody        = errors.New("empty request body")
+	ErrInvalidExpiresAt = errors.New("invalid expires, must be a positive duration, a future RFC3339 timestamp or \"never\"")
+	ErrInvalidMaxViews  = errors.New("invalid max_views, must be a positive number")
+	ErrPasswordRequired = errors.New("password required")
+	ErrInvalidPassword  = errors.New("invalid password")
+	ErrRemovePassword   = errors.New("invalid password, must be \"none\" with an empty X-Password header to remove the password")
+	ErrNotEncrypted     = errors.New("content of encrypted documents must be base64 encoded ciphertext")
+	ErrInvalidPrivate   = errors.New("invalid private, must be true or false")
+	ErrInvalidPublic    = errors.New("invalid public, must be true or false")
+	ErrPrivatePublic    = errors.New("documents can't be private and public")
 	ErrContentTooLarge  = func(maxLength int) error {
 		return fmt.Errorf("content too large, must be less than %d chars", maxLength)
 	}
+	ErrBodyTooLarge = func(maxSize int64) error {
+		return fmt.Errorf("request body too large, must be at most %d bytes", maxSize)
+	}
 )
 
 type (
@@ -42,7 +66,20 @@ type (
 		Content   template.HTML
 		Formatted template.HTML
 		CSS       template.CSS
-		Language  string
+		// RenderError is why the document is shown without highlighting
+		RenderError string
+		Language    string
+
+		ExpiresAtLabel string
+		ExpiresAtTime  string
+		ViewLimited    bool
+		ViewsLeft      int64
+
+		PasswordRequired bool
+		Encrypted        bool
+		Private          bool
+		Public           bool
+		PrivateLocked    bool
 
 		Versions []DocumentVersion
 		Lexers   []string
@@ -61,18 +98,36 @@ type (
 	DocumentResponse struct {
 		Key          string        `json:"key,omitempty"`
 		Version      int64         `json:"version"`
+		CreatedAt    int64         `json:"created_at,omitempty"`
 		VersionLabel string        `json:"version_label,omitempty"`
 		VersionTime  string        `json:"version_time,omitempty"`
 		Data         string        `json:"data,omitempty"`
+		ContentHash  string        `json:"content_hash,omitempty"`
 		Formatted    template.HTML `json:"formatted,omitempty"`
 		CSS          template.CSS  `json:"css,omitempty"`
+		RenderError  string        `json:"render_error,omitempty"`
 		Language     string        `json:"language"`
+		ExpiresAt    int64         `json:"expires_at,omitempty"`
+		ViewsLeft    *int64        `json:"views_left,omitempty"`
+		Password     bool          `json:"password,omitempty"`
+		Encrypted    bool          `json:"encrypted,omitempty"`
+		Private      bool          `json:"private,omitempty"`
+		Public       bool          `json:"public,omitempty"`
 		Token        string        `json:"token,omitempty"`
 	}
 	ShareRequest struct {
 		Permissions []Permission `json:"permissions"`
+		TTL         string       `json:"ttl"`
 	}
 	ShareResponse struct {
+		Token     string `json:"token"`
+		ExpiresAt int64  `json:"expires_at,omitempty"`
+	}
+	RevokeRequest struct {
+		Token string `json:"token"`
+		All   bool   `json:"all"`
+	}
+	RevokeResponse struct {
 		Token string `json:"token"`
 	}
 	DeleteResponse struct {
@@ -83,6 +138,8 @@ type (
 		Status    int    `json:"status"`
 		Path      string `json:"path"`
 		RequestID string `json:"request_id"`
+		// Version is the current version of the document if the request failed because of a version conflict
+		Version int64 `json:"version,omitempty"`
 	}
 )
 
@@ -91,6 +148,7 @@ func (s *Server) Routes() http.Handler {
 	r.Use(middleware.CleanPath)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
+	r.Use(s.metrics.Middleware)
 	r.Use(middleware.Timeout(30 * time.Second))
 	r.Use(middleware.Compress(5))
 	r.Use(middleware.Maybe(
@@ -111,6 +169,10 @@ func (s *Server) Routes() http.Handler {
 		r.Mount("/debug", middleware.Profiler())
 	}
 
+	if s.metrics != nil && s.cfg.Metrics.ListenAddr == "" {
+		r.Handle("/metrics", s.metrics.Handler())
+	}
+
 	r.Mount("/assets", http.FileServer(s.assets))
 	r.Handle("/favicon.png", s.file("/assets/favicon.png"))
 	r.Handle("/favicon-light.png", s.file("/assets/favicon-light.png"))
@@ -126,11 +188,14 @@ func (s *Server) Routes() http.Handler {
 		})
 		r.Route("/documents", func(r chi.Router) {
 			r.Post("/", s.PostDocument)
+			r.Post("/search", s.PostDocumentSearch)
 			r.Route("/{documentID}", func(r chi.Router) {
 				r.Get("/", s.GetDocument)
 				r.Patch("/", s.PatchDocument)
 				r.Delete("/", s.DeleteDocument)
 				r.Post("/share", s.PostDocumentShare)
+				r.Post("/revoke", s.PostDocumentRevoke)
+				r.Get("/diff", s.GetDocumentDiff)
 				r.Route("/versions", func(r chi.Router) {
 					r.Get("/", s.DocumentVersions)
 					r.Route("/{version}", func(r chi.Router) {
@@ -143,6 +208,7 @@ func (s *Server) Routes() http.Handler {
 		r.Get("/version", s.GetVersion)
 		r.Get("/{documentID}", s.GetPrettyDocument)
 		r.Head("/{documentID}", s.GetPrettyDocument)
+		r.Get("/{documentID}/diff", s.GetPrettyDocumentDiff)
 		r.Get("/{documentID}/{version}", s.GetPrettyDocument)
 		r.Head("/{documentID}/{version}", s.GetPrettyDocument)
 		r.Get("/", s.GetPrettyDocument)
@@ -163,47 +229,46 @@ func (s *Server) DocumentVersions(w http.ResponseWriter, r *http.Request) {
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
-	if len(versions) == 0 {
+	if len(versions) == 0 || !s.canReadDocument(r, versions[0]) {
 		s.documentNotFound(w, r)
 		return
 	}
+	if err = s.checkDocumentPassword(r, versions[0]); err != nil {
+		s.error(w, r, err, http.StatusUnauthorized)
+		return
+	}
+	var viewsLeft *int64
+	if withContent {
+		if err = s.viewDocument(r, &versions[0]); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.documentNotFound(w, r)
+				return
+			}
+			s.log(r, "view document versions", err)
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		viewsLeft = versions[0].ViewsLeft
+	}
 	var response []DocumentResponse
 	for _, version := range versions {
 		response = append(response, DocumentResponse{
-			Version:  version.Version,
-			Data:     version.Content,
-			Language: version.Language,
+			Version:     version.Version,
+			CreatedAt:   version.CreatedAt,
+			Data:        version.Content,
+			ContentHash: version.ContentHash,
+			Language:    version.Language,
+			ExpiresAt:   int64OrZero(version.ExpiresAt),
+			ViewsLeft:   viewsLeft,
+			Password:    version.PasswordHash != nil,
+			Encrypted:   version.Encrypted,
+			Private:     version.Private,
+			Public:      version.Public,
 		})
 	}
 	s.ok(w, r, response)
 }
 
-func (s *Server) GetDocumentVersion(w http.ResponseWriter, r *http.Request) {
-	documentID := chi.URLParam(r, "documentID")
-	version := parseDocumentVersion(r, s, w)
-	if version == -1 {
-		return
-	}
-
-	document, err := s.db.GetDocumentVersion(r.Context(), documentID, version)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			s.documentNotFound(w, r)
-			return
-		}
-		s.log(r, "get document version", err)
-		s.error(w, r, err, http.StatusInternalServerError)
-		return
-	}
-
-	s.ok(w, r, DocumentResponse{
-		Key:      document.ID,
-		Version:  document.Version,
-		Data:     document.Content,
-		Language: document.Language,
-	})
-}
-
 func parseDocumentVersion(r *http.Request, s *Server, w http.ResponseWriter) int64 {
 	version := chi.URLParam(r, "version")
 	if version == "" {
@@ -226,9 +291,11 @@ func (s *Server) GetPrettyDocument(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var (
-		document  Document
-		documents []Document
-		err       error
+		document         Document
+		documents        []Document
+		passwordRequired bool
+		privateLocked    bool
+		err              error
 	)
 	if documentID != "" {
 		if version == 0 {
@@ -254,23 +321,79 @@ func (s *Server) GetPrettyDocument(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		documents, err = s.db.GetDocumentVersions(r.Context(), documentID, false)
-		if err != nil {
-			s.log(r, "get pretty document versions", err)
-			s.prettyError(w, r, err, http.StatusInternalServerError)
+		if !s.canReadDocument(r, document) {
+			// the client has to fetch the document with its token first
+			privateLocked = true
+			document = Document{
+				ID:      document.ID,
+				Version: document.Version,
+				Private: true,
+			}
+		} else if err = s.checkDocumentPassword(r, document); err != nil {
+			// the client has to unlock the document with the password first
+			passwordRequired = true
+			document = Document{
+				ID:      document.ID,
+				Version: document.Version,
+			}
+		} else {
+			if err = s.viewDocument(r, &document); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					s.redirectRoot(w, r)
+					return
+				}
+				s.log(r, "view pretty document", err)
+				s.prettyError(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			documents, err = s.db.GetDocumentVersions(r.Context(), documentID, false)
+			if err != nil {
+				s.log(r, "get pretty document versions", err)
+				s.prettyError(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	theme := "dark"
+	if themeCookie, err := r.Cookie("theme"); err == nil && themeCookie.Value != "" {
+		theme = themeCookie.Value
+	}
+
+	if privateLocked || passwordRequired {
+		// the page changes once the client has the token or password, which we don't see in the request
+		w.Header().Set("Cache-Control", "no-store")
+	} else if documentID != "" {
+		// the page lists all versions and is rendered with the style & theme of the client
+		if s.cacheDocument(w, r, document, false, "pretty", s.version, renderStyle(r).Name, theme, strconv.Itoa(len(documents))) {
 			return
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
+	status := http.StatusOK
+	if privateLocked {
+		status = http.StatusNotFound
+	} else if passwordRequired {
+		status = http.StatusUnauthorized
+	}
 	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
 		return
 	}
 
+	rendered, err := s.renderDocument(r, document, "html")
+	if err != nil {
+		s.log(r, "render document", err)
+		s.prettyError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	cacheRendered(w, rendered)
+	w.WriteHeader(status)
+
 	versions := make([]DocumentVersion, 0, len(documents))
 	now := time.Now()
 	for _, documentVersion := range documents {
-		label, timeStr := FormatDocumentVersion(now, documentVersion.Version)
+		label, timeStr := FormatDocumentVersion(now, documentVersion.CreatedAt)
 		versions = append(versions, DocumentVersion{
 			Version: documentVersion.Version,
 			Label:   label,
@@ -278,30 +401,35 @@ func (s *Server) GetPrettyDocument(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	formatted, css, language, style, err := s.renderDocument(r, document, "html")
-	if err != nil {
-		s.log(r, "render document", err)
-		s.prettyError(w, r, err, http.StatusInternalServerError)
-		return
-	}
-
-	theme := "dark"
-	if themeCookie, err := r.Cookie("theme"); err == nil && themeCookie.Value != "" {
-		theme = themeCookie.Value
+	var expiresAtLabel, expiresAtTime string
+	if document.ExpiresAt != nil {
+		expiresAtLabel, expiresAtTime = FormatDocumentExpiresAt(now, *document.ExpiresAt)
 	}
 
 	vars := TemplateVariables{
-		ID:        document.ID,
-		Version:   document.Version,
-		Content:   template.HTML(document.Content),
-		Formatted: formatted,
-		CSS:       css,
-		Language:  language,
+		ID:          document.ID,
+		Version:     document.Version,
+		Content:     template.HTML(document.Content),
+		Formatted:   rendered.HTML,
+		CSS:         rendered.CSS,
+		RenderError: rendered.renderError(),
+		Language:    rendered.Language,
+
+		ExpiresAtLabel: expiresAtLabel,
+		ExpiresAtTime:  expiresAtTime,
+		ViewLimited:    document.ViewsLeft != nil,
+		ViewsLeft:      int64OrZero(document.ViewsLeft),
+
+		PasswordRequired: passwordRequired,
+		Encrypted:        document.Encrypted,
+		Private:          document.Private,
+		Public:           document.Public,
+		PrivateLocked:    privateLocked,
 
 		Versions: versions,
 		Lexers:   lexers.Names(false),
 		Styles:   styles.Names(),
-		Style:    style,
+		Style:    rendered.Style,
 		Theme:    theme,
 
 		Max:  s.cfg.MaxDocumentSize,
@@ -312,11 +440,9 @@ func (s *Server) GetPrettyDocument(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) renderDocument(r *http.Request, document Document, formatterName string) (template.HTML, template.CSS, string, string, error) {
-	var (
-		styleName    string
-		languageName = document.Language
-	)
+// renderStyle returns the style to render documents with, the style query parameter overrides the style cookie.
+func renderStyle(r *http.Request) *chroma.Style {
+	var styleName string
 	if styleCookie, err := r.Cookie("style"); err == nil {
 		styleName = styleCookie.Value
 	}
@@ -329,46 +455,103 @@ func (s *Server) renderDocument(r *http.Request, document Document, formatterNam
 	if style == nil {
 		style = styles.Fallback
 	}
+	return style
+}
+
+func (s *Server) renderDocument(r *http.Request, document Document, formatterName string) (renderedDocument, error) {
+	languageName := document.Language
+	style := renderStyle(r)
+	if document.Encrypted {
+		// we only have the ciphertext of encrypted documents, the client renders them
+		return renderedDocument{
+			Language: document.Language,
+			Style:    style.Name,
+		}, nil
+	}
 	lexer := lexers.Get(languageName)
 	if lexer == nil {
 		lexer = lexers.Fallback
 	}
-
-	iterator, err := lexer.Tokenise(nil, document.Content)
-	if err != nil {
-		return "", "", "", "", err
-	}
-
 	formatter := formatters.Get(formatterName)
 	if formatter == nil {
 		formatter = formatters.Fallback
+		// don't use unknown formatter names as metric labels
+		formatterName = "fallback"
+	}
+
+	// only stored document versions are cached, the editor and diffs render content without a version
+	cached := document.Version != 0
+	key := renderKey{
+		documentID:  document.ID,
+		version:     document.Version,
+		contentHash: document.ContentHash,
+		language:    lexer.Config().Name,
+		style:       style.Name,
+		formatter:   formatterName,
+	}
+	if cached {
+		if rendered, ok := s.renderCache.get(r.Context(), key); ok {
+			rendered.Style = style.Name
+			return rendered, nil
+		}
+	}
+
+	rendered := renderedDocument{
+		Language: lexer.Config().Name,
+		Style:    style.Name,
+	}
+	if document.ID == "" {
+		rendered.Language = "auto"
 	}
 
-	buff := new(bytes.Buffer)
-	if err = formatter.Format(buff, style, iterator); err != nil {
-		return "", "", "", "", err
+	start := time.Now()
+	formatted, err := s.renderer.Render(r.Context(), document.Content, lexer, formatter, style)
+	switch {
+	case errors.Is(err, ErrRenderTooLarge), errors.Is(err, ErrRenderTimeout):
+		reason := "too_large"
+		if errors.Is(err, ErrRenderTimeout) {
+			reason = "timeout"
+		}
+		s.metrics.RenderFailed(formatterName, reason)
+
+		// show the document without highlighting instead
+		buff := new(bytes.Buffer)
+		if fallbackErr := formatter.Format(buff, style, plaintextIterator(document.Content)); fallbackErr != nil {
+			return renderedDocument{}, fallbackErr
+		}
+		rendered.HTML = template.HTML(buff.String())
+		rendered.Unhighlighted = err
+	case err != nil:
+		if !errors.Is(err, context.Canceled) {
+			s.metrics.RenderFailed(formatterName, "error")
+		}
+		return renderedDocument{}, err
+	default:
+		s.metrics.ObserveRender(formatterName, start)
+		rendered.HTML = template.HTML(formatted)
 	}
 
-	cssBuff := new(bytes.Buffer)
 	if htmlFormatter, ok := formatter.(*html.Formatter); ok {
+		cssBuff := new(bytes.Buffer)
 		if err = htmlFormatter.WriteCSS(cssBuff, style); err != nil {
-			return "", "", "", "", err
+			return renderedDocument{}, err
 		}
+		rendered.CSS = template.CSS(cssBuff.String())
 	}
 
-	language := lexer.Config().Name
-	if document.ID == "" {
-		language = "auto"
+	if cached && rendered.Unhighlighted == nil {
+		s.renderCache.set(r.Context(), key, rendered)
 	}
-	return template.HTML(buff.String()), template.CSS(cssBuff.String()), language, style.Name, nil
+	return rendered, nil
 }
 
 func (s *Server) GetVersion(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(s.version))
 }
 
-func FormatDocumentVersion(now time.Time, versionRaw int64) (string, string) {
-	version := time.Unix(versionRaw, 0)
+// FormatDocumentVersion formats the time a version was created at relative to now.
+func FormatDocumentVersion(now time.Time, createdAtRaw int64) (string, string) {
+	version := time.Unix(createdAtRaw, 0)
 	timeStr := version.Format("02/01/2006 15:04:05")
 	if version.Year() < now.Year() {
 		return fmt.Sprintf("%d years ago", now.Year()-version.Year()), timeStr
@@ -388,13 +571,35 @@ func FormatDocumentVersion(now time.Time, versionRaw int64) (string, string) {
 	return fmt.Sprintf("%d seconds ago", now.Second()-version.Second()), timeStr
 }
 
+func FormatDocumentExpiresAt(now time.Time, expiresAtRaw int64) (string, string) {
+	expiresAt := time.Unix(expiresAtRaw, 0)
+	timeStr := expiresAt.Format("02/01/2006 15:04:05")
+	remaining := expiresAt.Sub(now)
+	if remaining >= 24*time.Hour {
+		return fmt.Sprintf("expires in %d days", remaining/(24*time.Hour)), timeStr
+	}
+	if remaining >= time.Hour {
+		return fmt.Sprintf("expires in %d hours", remaining/time.Hour), timeStr
+	}
+	if remaining >= time.Minute {
+		return fmt.Sprintf("expires in %d minutes", remaining/time.Minute), timeStr
+	}
+	if remaining > 0 {
+		return fmt.Sprintf("expires in %d seconds", remaining/time.Second), timeStr
+	}
+	return "expired", timeStr
+}
+
 func (s *Server) GetRawDocument(w http.ResponseWriter, r *http.Request) {
 	document := s.getDocument(w, r)
 	if document == nil {
 		return
 	}
 
-	var formatted template.HTML
+	var (
+		formatted     template.HTML
+		renderOptions []string
+	)
 	query := r.URL.Query()
 	formatter := query.Get("formatter")
 	if formatter != "" {
@@ -404,13 +609,22 @@ func (s *Server) GetRawDocument(w http.ResponseWriter, r *http.Request) {
 		if query.Get("language") != "" {
 			document.Language = query.Get("language")
 		}
-		var err error
-		formatted, _, _, _, err = s.renderDocument(r, *document, formatter)
+		renderOptions = []string{"raw", formatter, document.Language, renderStyle(r).Name}
+	}
+	// the raw content of a pinned version never changes, unlike the metadata in JSON responses and the versions listed on pages
+	if s.cacheDocument(w, r, *document, chi.URLParam(r, "version") != "", renderOptions...) {
+		return
+	}
+
+	if formatter != "" {
+		rendered, err := s.renderDocument(r, *document, formatter)
 		if err != nil {
 			s.log(r, "render document", err)
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
+		cacheRendered(w, rendered)
+		formatted = rendered.HTML
 	}
 
 	content := document.Content
@@ -418,13 +632,20 @@ func (s *Server) GetRawDocument(w http.ResponseWriter, r *http.Request) {
 		content = string(formatted)
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-	if r.Method == http.MethodHead {
-		w.Header().Set("Content-Length", strconv.Itoa(len([]byte(content))))
-		w.WriteHeader(http.StatusOK)
-		return
+	w.Header().Set("Content-Type", rawContentType(*document, formatter, s.cfg.RawContentTypes))
+	// browsers must neither guess the type of documents nor run scripts in them
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if download, _ := strconv.ParseBool(query.Get("download")); download {
+		w.Header().Set("Content-Disposition", contentDisposition(rawFilename(*document, formatter)))
+	}
+	if document.ViewsLeft != nil {
+		// every view of view limited documents has to be counted, see cacheDocument
+		r.Header.Del("If-None-Match")
+		r.Header.Del("If-Modified-Since")
 	}
-	_, _ = w.Write([]byte(content))
+	// ServeContent handles Range & If-Range requests with the ETag & Last-Modified set by cacheDocument
+	http.ServeContent(w, r, "", time.Unix(document.CreatedAt, 0), strings.NewReader(content))
 }
 
 func (s *Server) GetDocument(w http.ResponseWriter, r *http.Request) {
@@ -433,29 +654,34 @@ func (s *Server) GetDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if r.Method == http.MethodHead {
-		return
-	}
-
-	var (
-		formatted template.HTML
-		css       template.CSS
-		language  string
-	)
+	var renderOptions []string
 	query := r.URL.Query()
 	formatter := query.Get("formatter")
 	if formatter != "" {
 		if query.Get("language") != "" {
 			document.Language = query.Get("language")
 		}
+		renderOptions = []string{"json", formatter, document.Language, renderStyle(r).Name}
+	}
+	if s.cacheDocument(w, r, *document, false, renderOptions...) {
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var rendered renderedDocument
+	if formatter != "" {
 		var err error
-		formatted, css, language, _, err = s.renderDocument(r, *document, formatter)
+		rendered, err = s.renderDocument(r, *document, formatter)
 		if err != nil {
 			s.log(r, "render document", err)
 			s.error(w, r, err, http.StatusInternalServerError)
 			return
 		}
+		cacheRendered(w, rendered)
 	}
 
 	var version int64
@@ -464,17 +690,27 @@ func (s *Server) GetDocument(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.ok(w, r, DocumentResponse{
-		Key:       document.ID,
-		Version:   version,
-		Data:      document.Content,
-		Formatted: formatted,
-		CSS:       css,
-		Language:  language,
+		Key:         document.ID,
+		Version:     version,
+		CreatedAt:   document.CreatedAt,
+		Data:        document.Content,
+		ContentHash: document.ContentHash,
+		Formatted:   rendered.HTML,
+		CSS:         rendered.CSS,
+		RenderError: rendered.renderError(),
+		Language:    rendered.Language,
+		ExpiresAt:   int64OrZero(document.ExpiresAt),
+		ViewsLeft:   document.ViewsLeft,
+		Password:    document.PasswordHash != nil,
+		Encrypted:   document.Encrypted,
+		Private:     document.Private,
+		Public:      document.Public,
 	})
 }
 
 func (s *Server) PostDocument(w http.ResponseWriter, r *http.Request) {
 	language := r.URL.Query().Get("language")
+	encrypted := r.URL.Query().Get("encrypted") == "true"
 	content := s.readBody(w, r)
 	if content == "" {
 		return
@@ -490,8 +726,37 @@ func (s *Server) PostDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expiresAt, _, err := s.parseExpiresAt(r)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	maxViews, err := parseMaxViews(r)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, _, err := hashPassword(r)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	private, public, _, err := parseVisibility(r, Document{})
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if encrypted && !isEncryptedContent(content) {
+		s.error(w, r, ErrNotEncrypted, http.StatusBadRequest)
+		return
+	}
+
 	var lexer chroma.Lexer
-	if language == "auto" || language == "" {
+	if (language == "auto" || language == "") && !encrypted {
 		lexer = lexers.Analyse(content)
 	} else {
 		lexer = lexers.Get(language)
@@ -500,22 +765,30 @@ func (s *Server) PostDocument(w http.ResponseWriter, r *http.Request) {
 		lexer = lexers.Fallback
 	}
 
-	document, err := s.db.CreateDocument(r.Context(), content, lexer.Config().Name)
+	document, err := s.db.CreateDocument(r.Context(), Document{
+		Content:      content,
+		Language:     lexer.Config().Name,
+		ExpiresAt:    expiresAt,
+		ViewsLeft:    maxViews,
+		PasswordHash: passwordHash,
+		Encrypted:    encrypted,
+		Private:      private,
+		Public:       public,
+	})
 	if err != nil {
 		s.log(r, "creating document", err)
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	s.metrics.DocumentCreated()
 
 	var (
-		data          string
-		formatted     template.HTML
-		css           template.CSS
-		finalLanguage string
+		data     string
+		rendered renderedDocument
 	)
 	formatter := r.URL.Query().Get("formatter")
 	if formatter != "" {
-		formatted, css, finalLanguage, _, err = s.renderDocument(r, document, formatter)
+		rendered, err = s.renderDocument(r, document, formatter)
 		if err != nil {
 			s.log(r, "render document", err)
 			s.error(w, r, err, http.StatusInternalServerError)
@@ -524,23 +797,33 @@ func (s *Server) PostDocument(w http.ResponseWriter, r *http.Request) {
 		data = document.Content
 	}
 
-	token, err := s.NewToken(document.ID, []Permission{PermissionWrite, PermissionDelete, PermissionShare})
+	token, err := s.NewToken(document.ID, []Permission{PermissionRead, PermissionWrite, PermissionDelete, PermissionShare}, nil)
 	if err != nil {
 		s.log(r, "creating jwt token", err)
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	versionLabel, versionTime := FormatDocumentVersion(time.Now(), document.Version)
+	versionLabel, versionTime := FormatDocumentVersion(time.Now(), document.CreatedAt)
+	w.Header().Set("ETag", VersionETag(document.ID, document.Version))
 	s.ok(w, r, DocumentResponse{
 		Key:          document.ID,
 		Version:      document.Version,
+		CreatedAt:    document.CreatedAt,
 		VersionLabel: versionLabel,
 		VersionTime:  versionTime,
 		Data:         data,
-		Formatted:    formatted,
-		CSS:          css,
-		Language:     finalLanguage,
+		ContentHash:  document.ContentHash,
+		Formatted:    rendered.HTML,
+		CSS:          rendered.CSS,
+		RenderError:  rendered.renderError(),
+		Language:     rendered.Language,
+		ExpiresAt:    int64OrZero(document.ExpiresAt),
+		ViewsLeft:    document.ViewsLeft,
+		Password:     document.PasswordHash != nil,
+		Encrypted:    document.Encrypted,
+		Private:      document.Private,
+		Public:       document.Public,
 		Token:        token,
 	})
 }
@@ -570,8 +853,47 @@ func (s *Server) PatchDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expiresAt, updateExpiresAt, err := s.parseExpiresAt(r)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, updatePassword, err := hashPassword(r)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	currentDocument, err := s.db.GetDocument(r.Context(), documentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.documentNotFound(w, r)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	matchVersion, ok := parseIfMatch(r, currentDocument.ID, currentDocument.Version)
+	if !ok {
+		s.versionConflict(w, r, currentDocument.ID, currentDocument.Version)
+		return
+	}
+
+	private, public, updateVisibility, err := parseVisibility(r, currentDocument)
+	if err != nil {
+		s.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if currentDocument.Encrypted && !isEncryptedContent(content) {
+		s.error(w, r, ErrNotEncrypted, http.StatusBadRequest)
+		return
+	}
+
 	var lexer chroma.Lexer
-	if language == "auto" || language == "" {
+	if (language == "auto" || language == "") && !currentDocument.Encrypted {
 		lexer = lexers.Analyse(content)
 	} else {
 		lexer = lexers.Get(language)
@@ -580,25 +902,38 @@ func (s *Server) PatchDocument(w http.ResponseWriter, r *http.Request) {
 		lexer = lexers.Fallback
 	}
 
-	document, err := s.db.UpdateDocument(r.Context(), documentID, content, lexer.Config().Name)
+	// the metadata is updated in the same transaction as the content, so the new version is never visible with the old metadata
+	document, err := s.db.UpdateDocument(r.Context(), documentID, content, lexer.Config().Name, matchVersion, DocumentUpdate{
+		UpdateExpiresAt:  updateExpiresAt,
+		ExpiresAt:        expiresAt,
+		UpdatePassword:   updatePassword,
+		PasswordHash:     passwordHash,
+		UpdateVisibility: updateVisibility,
+		Private:          private,
+		Public:           public,
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			s.documentNotFound(w, r)
 			return
 		}
+		if errors.Is(err, ErrVersionConflict) {
+			s.latestVersionConflict(w, r, documentID)
+			return
+		}
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	s.renderCache.Invalidate(r.Context(), documentID)
+	s.metrics.DocumentUpdated()
 
 	var (
-		data          string
-		formatted     template.HTML
-		css           template.CSS
-		finalLanguage string
+		data     string
+		rendered renderedDocument
 	)
 	formatter := r.URL.Query().Get("formatter")
 	if formatter != "" {
-		formatted, css, finalLanguage, _, err = s.renderDocument(r, document, formatter)
+		rendered, err = s.renderDocument(r, document, formatter)
 		if err != nil {
 			s.log(r, "render document", err)
 			s.error(w, r, err, http.StatusInternalServerError)
@@ -607,16 +942,26 @@ func (s *Server) PatchDocument(w http.ResponseWriter, r *http.Request) {
 		data = document.Content
 	}
 
-	versionLabel, versionTime := FormatDocumentVersion(time.Now(), document.Version)
+	versionLabel, versionTime := FormatDocumentVersion(time.Now(), document.CreatedAt)
+	w.Header().Set("ETag", VersionETag(document.ID, document.Version))
 	s.ok(w, r, DocumentResponse{
 		Key:          document.ID,
 		Version:      document.Version,
+		CreatedAt:    document.CreatedAt,
 		VersionLabel: versionLabel,
 		VersionTime:  versionTime,
 		Data:         data,
-		Formatted:    formatted,
-		CSS:          css,
-		Language:     finalLanguage,
+		ContentHash:  document.ContentHash,
+		Formatted:    rendered.HTML,
+		CSS:          rendered.CSS,
+		RenderError:  rendered.renderError(),
+		Language:     rendered.Language,
+		ExpiresAt:    int64OrZero(document.ExpiresAt),
+		ViewsLeft:    document.ViewsLeft,
+		Password:     document.PasswordHash != nil,
+		Encrypted:    document.Encrypted,
+		Private:      document.Private,
+		Public:       document.Public,
 	})
 }
 
@@ -633,10 +978,37 @@ func (s *Server) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var matchVersion int64
+	if r.Header.Get(IfMatchHeader) != "" {
+		var (
+			document Document
+			err      error
+		)
+		if version == 0 {
+			document, err = s.db.GetDocument(r.Context(), documentID)
+		} else {
+			document, err = s.db.GetDocumentVersion(r.Context(), documentID, version)
+		}
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.documentNotFound(w, r)
+				return
+			}
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		var ok bool
+		if matchVersion, ok = parseIfMatch(r, document.ID, document.Version); !ok {
+			s.versionConflict(w, r, document.ID, document.Version)
+			return
+		}
+	}
+
 	var err error
 	if version == 0 {
-		err = s.db.DeleteDocument(r.Context(), documentID)
+		err = s.db.DeleteDocument(r.Context(), documentID, matchVersion)
 	} else {
+		// versions never change, so they don't have to be checked against concurrent updates again
 		err = s.db.DeleteDocumentByVersion(r.Context(), documentID, version)
 	}
 	if err != nil {
@@ -644,9 +1016,15 @@ func (s *Server) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 			s.documentNotFound(w, r)
 			return
 		}
+		if errors.Is(err, ErrVersionConflict) {
+			s.latestVersionConflict(w, r, documentID)
+			return
+		}
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	s.renderCache.Invalidate(r.Context(), documentID)
+	s.metrics.DocumentDeleted()
 	if version == 0 {
 		w.WriteHeader(http.StatusNoContent)
 	}
@@ -666,8 +1044,7 @@ func (s *Server) PostDocumentShare(w http.ResponseWriter, r *http.Request) {
 	documentID := chi.URLParam(r, "documentID")
 
 	var shareRequest ShareRequest
-	if err := json.NewDecoder(r.Body).Decode(&shareRequest); err != nil {
-		s.error(w, r, err, http.StatusBadRequest)
+	if !s.decodeBody(w, r, &shareRequest) {
 		return
 	}
 
@@ -696,17 +1073,189 @@ func (s *Server) PostDocumentShare(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	token, err := s.NewToken(documentID, shareRequest.Permissions)
+	// shared tokens can't outlive the token they were shared with
+	var expiresAt *time.Time
+	if claims.Expiry != nil {
+		expiry := claims.Expiry.Time()
+		expiresAt = &expiry
+	}
+	if shareRequest.TTL != "" {
+		ttl, err := time.ParseDuration(shareRequest.TTL)
+		if err != nil || ttl <= 0 {
+			s.error(w, r, ErrInvalidTTL, http.StatusBadRequest)
+			return
+		}
+		if expiry := time.Now().Add(ttl); expiresAt == nil || expiry.Before(*expiresAt) {
+			expiresAt = &expiry
+		}
+	}
+
+	token, err := s.NewToken(documentID, shareRequest.Permissions, expiresAt)
 	if err != nil {
 		s.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	s.ok(w, r, ShareResponse{
-		Token: token,
+		Token:     token,
+		ExpiresAt: int64OrZero(unixOrNil(expiresAt)),
 	})
 }
 
+func (s *Server) PostDocumentRevoke(w http.ResponseWriter, r *http.Request) {
+	documentID := chi.URLParam(r, "documentID")
+
+	var revokeRequest RevokeRequest
+	if !s.decodeBody(w, r, &revokeRequest) {
+		return
+	}
+
+	claims := s.GetClaims(r)
+	if claims.Subject != documentID || len(claims.Permissions) == 0 {
+		s.documentNotFound(w, r)
+		return
+	}
+
+	if revokeRequest.All {
+		// revoking all tokens requires all permissions, as the caller gets the only valid token afterwards
+		for _, permission := range []Permission{PermissionWrite, PermissionDelete, PermissionShare} {
+			if !slices.Contains(claims.Permissions, permission) {
+				s.error(w, r, ErrPermissionDenied(permission), http.StatusForbidden)
+				return
+			}
+		}
+
+		// tokens are issued with second precision, so the new token is issued in the second after the revocation
+		revokedAt := time.Now().Add(time.Second)
+		if err := s.db.RevokeAllTokens(r.Context(), documentID, revokedAt.Unix()); err != nil {
+			s.log(r, "revoke all tokens", err)
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		tokenClaims := newClaims(documentID, claims.Permissions, nil)
+		tokenClaims.IssuedAt = jwt.NewNumericDate(revokedAt)
+		token, err := s.signClaims(tokenClaims)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		s.ok(w, r, RevokeResponse{
+			Token: token,
+		})
+		return
+	}
+
+	if revokeRequest.Token == "" {
+		s.error(w, r, ErrNoRevokeToken, http.StatusBadRequest)
+		return
+	}
+	revokeClaims, err := s.parseToken(revokeRequest.Token)
+	if err != nil || revokeClaims.Subject != documentID {
+		s.error(w, r, ErrInvalidRevokeToken, http.StatusBadRequest)
+		return
+	}
+	if revokeClaims.ID == "" {
+		s.error(w, r, ErrRevokeTokenNoID, http.StatusBadRequest)
+		return
+	}
+
+	// everyone can revoke their own token, other tokens can only be revoked with the share permission and all of their permissions
+	if revokeClaims.ID != claims.ID {
+		if !slices.Contains(claims.Permissions, PermissionShare) {
+			s.error(w, r, ErrPermissionDenied(PermissionShare), http.StatusForbidden)
+			return
+		}
+		for _, permission := range revokeClaims.Permissions {
+			if !slices.Contains(claims.Permissions, permission) {
+				s.error(w, r, ErrPermissionDenied(permission), http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	var expiresAt *int64
+	if revokeClaims.Expiry != nil {
+		expiry := revokeClaims.Expiry.Time().Unix()
+		expiresAt = &expiry
+	}
+	if err = s.db.RevokeToken(r.Context(), documentID, revokeClaims.ID, expiresAt); err != nil {
+		s.log(r, "revoke token", err)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VersionETag returns the strong ETag of a document version like "{key}-{version}", which is sent in the If-Match header to update or delete the document only if it's still at that version.
+// It contains the document key, so versions of different documents never share an ETag.
+func VersionETag(documentID string, version int64) string {
+	return `"` + documentID + "-" + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseVersionETag returns the document key and version of an ETag returned for a document, rendered documents have a hash of the render options appended to the VersionETag.
+func ParseVersionETag(etag string) (string, int64, bool) {
+	etag = strings.TrimPrefix(etag, "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return "", 0, false
+	}
+	parts := strings.Split(etag[1:len(etag)-1], "-")
+	if len(parts) < 2 || parts[0] == "" {
+		return "", 0, false
+	}
+	version, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], version, true
+}
+
+// parseIfMatch checks the If-Match header against the current version of the document.
+// It returns the version the change has to be based on, which is 0 if the header is missing or "*", and false if no ETag matches.
+func parseIfMatch(r *http.Request, documentID string, version int64) (int64, bool) {
+	ifMatch := r.Header.Values(IfMatchHeader)
+	if len(ifMatch) == 0 {
+		return 0, true
+	}
+	for _, etag := range strings.Split(strings.Join(ifMatch, ","), ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" {
+			return 0, true
+		}
+		// weak ETags never match, If-Match requires a strong comparison
+		if etag == VersionETag(documentID, version) {
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+// latestVersionConflict responds to a change of a document which was based on an outdated version with the latest version of the document.
+func (s *Server) latestVersionConflict(w http.ResponseWriter, r *http.Request, documentID string) {
+	document, err := s.db.GetDocument(r.Context(), documentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.documentNotFound(w, r)
+			return
+		}
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.versionConflict(w, r, document.ID, document.Version)
+}
+
+// versionConflict responds with 412 and the current version, so the client can fetch it and decide how to apply its change.
+func (s *Server) versionConflict(w http.ResponseWriter, r *http.Request, documentID string, version int64) {
+	w.Header().Set("ETag", VersionETag(documentID, version))
+	s.json(w, r, ErrorResponse{
+		Message:   fmt.Sprintf("%s, the current version is %d", ErrVersionConflict, version),
+		Status:    http.StatusPreconditionFailed,
+		Path:      r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+		Version:   version,
+	}, http.StatusPreconditionFailed)
+}
+
 func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) *Document {
 	documentID := chi.URLParam(r, "documentID")
 	if documentID == "" {
@@ -727,6 +1276,17 @@ func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) *Document {
 	} else {
 		document, err = s.db.GetDocumentVersion(r.Context(), documentID, version)
 	}
+	if err == nil {
+		if !s.canReadDocument(r, document) {
+			s.documentNotFound(w, r)
+			return nil
+		}
+		if err = s.checkDocumentPassword(r, document); err != nil {
+			s.error(w, r, err, http.StatusUnauthorized)
+			return nil
+		}
+		err = s.viewDocument(r, &document)
+	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			s.documentNotFound(w, r)
@@ -738,10 +1298,210 @@ func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) *Document {
 	return &document
 }
 
+// viewDocument counts a view of a view limited document.
+// HEAD requests and requests with a write token for the document are not counted as views.
+func (s *Server) viewDocument(r *http.Request, document *Document) error {
+	if document.ViewsLeft == nil || r.Method == http.MethodHead || s.isDocumentOwner(r, document.ID) {
+		return nil
+	}
+
+	viewsLeft, err := s.db.ViewDocument(r.Context(), document.ID)
+	if err != nil {
+		return err
+	}
+	if viewsLeft <= 0 {
+		// the document was deleted with its last view
+		s.renderCache.Invalidate(r.Context(), document.ID)
+	}
+	document.ViewsLeft = &viewsLeft
+	return nil
+}
+
+// checkDocumentPassword verifies the password of a password protected document.
+// Requests with a write token for the document don't need the password.
+func (s *Server) checkDocumentPassword(r *http.Request, document Document) error {
+	if document.PasswordHash == nil || s.isDocumentOwner(r, document.ID) {
+		return nil
+	}
+	password := r.Header.Get(PasswordHeader)
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(*document.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// canReadDocument checks if the document is public or the request has a token with the read permission for it.
+func (s *Server) canReadDocument(r *http.Request, document Document) bool {
+	if !document.Private {
+		return true
+	}
+	claims := s.GetClaims(r)
+	return claims.Subject == document.ID && slices.Contains(claims.Permissions, PermissionRead)
+}
+
+func (s *Server) isDocumentOwner(r *http.Request, documentID string) bool {
+	claims := s.GetClaims(r)
+	return claims.Subject == documentID && slices.Contains(claims.Permissions, PermissionWrite)
+}
+
+// hashPassword hashes the password of the X-Password header and returns if the password should be updated.
+// The password query parameter "none" with an empty X-Password header removes the password.
+func hashPassword(r *http.Request) (*string, bool, error) {
+	password := r.Header.Get(PasswordHeader)
+	if value := r.URL.Query().Get("password"); value != "" {
+		if value != "none" || password != "" {
+			return nil, false, ErrRemovePassword
+		}
+		return nil, true, nil
+	}
+	if password == "" {
+		return nil, false, nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, false, err
+	}
+	passwordHash := string(hash)
+	return &passwordHash, true, nil
+}
+
+// isEncryptedContent checks if the content could be ciphertext produced by the clients, we can't verify more than that without the key.
+func isEncryptedContent(content string) bool {
+	_, err := base64.StdEncoding.DecodeString(content)
+	return err == nil
+}
+
+// parseVisibility parses the private and public query parameters on top of the current visibility of the document and returns if one of them was set.
+func parseVisibility(r *http.Request, document Document) (bool, bool, bool, error) {
+	query := r.URL.Query()
+	private, public, update := document.Private, document.Public, false
+	if value := query.Get("private"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, false, false, ErrInvalidPrivate
+		}
+		private, update = parsed, true
+	}
+	if value := query.Get("public"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, false, false, ErrInvalidPublic
+		}
+		public, update = parsed, true
+	}
+	if private && public {
+		return false, false, false, ErrPrivatePublic
+	}
+	return private, public, update, nil
+}
+
+func parseMaxViews(r *http.Request) (*int64, error) {
+	maxViewsStr := r.URL.Query().Get("max_views")
+	if maxViewsStr == "" {
+		return nil, nil
+	}
+	maxViews, err := strconv.ParseInt(maxViewsStr, 10, 64)
+	if err != nil || maxViews <= 0 {
+		return nil, ErrInvalidMaxViews
+	}
+	return &maxViews, nil
+}
+
+// parseExpiresAt parses the expires query parameter which can be a duration, a RFC3339 timestamp or "never".
+// The returned bool reports whether the parameter was set, if it wasn't the default expiration is returned.
+// Expirations are capped to DatabaseConfig.ExpireAfter if configured.
+func (s *Server) parseExpiresAt(r *http.Request) (*int64, bool, error) {
+	now := time.Now()
+	var maxExpiresAt *time.Time
+	if s.cfg.Database.ExpireAfter > 0 {
+		t := now.Add(s.cfg.Database.ExpireAfter)
+		maxExpiresAt = &t
+	}
+
+	expires := r.URL.Query().Get("expires")
+	if expires == "" {
+		return unixOrNil(maxExpiresAt), false, nil
+	}
+
+	var expiresAt *time.Time
+	if expires != "never" {
+		if duration, err := time.ParseDuration(expires); err == nil {
+			if duration <= 0 {
+				return nil, true, ErrInvalidExpiresAt
+			}
+			t := now.Add(duration)
+			expiresAt = &t
+		} else if t, err := time.Parse(time.RFC3339, expires); err == nil {
+			if !t.After(now) {
+				return nil, true, ErrInvalidExpiresAt
+			}
+			expiresAt = &t
+		} else {
+			return nil, true, ErrInvalidExpiresAt
+		}
+	}
+
+	if maxExpiresAt != nil && (expiresAt == nil || expiresAt.After(*maxExpiresAt)) {
+		expiresAt = maxExpiresAt
+	}
+	return unixOrNil(expiresAt), true, nil
+}
+
+func unixOrNil(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	unix := t.Unix()
+	return &unix
+}
+
+func int64OrZero(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// readBody reads the content of a document from the request body, which may be compressed with the gzip or zstd Content-Encoding.
+// Bodies larger than the max body size are rejected with 413 Request Entity Too Large, before and after decompressing them.
 func (s *Server) readBody(w http.ResponseWriter, r *http.Request) string {
-	content, err := io.ReadAll(r.Body)
+	maxSize := s.cfg.MaxBodySize
+	if maxSize > 0 {
+		// reject bodies we know are too large before reading any of it
+		if r.ContentLength > maxSize {
+			s.error(w, r, ErrBodyTooLarge(maxSize), http.StatusRequestEntityTooLarge)
+			return ""
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	contentEncoding := r.Header.Get("Content-Encoding")
+	body, err := decompressReader(contentEncoding, r.Body)
 	if err != nil {
-		s.error(w, r, err, http.StatusInternalServerError)
+		if errors.Is(err, ErrUnsupportedContentEncoding) {
+			s.error(w, r, err, http.StatusUnsupportedMediaType)
+			return ""
+		}
+		s.bodyError(w, r, err, maxSize)
+		return ""
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	if maxSize > 0 {
+		// compressed bodies may decompress to far more than the max body size
+		reader = io.LimitReader(body, maxSize+1)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		s.bodyError(w, r, err, maxSize)
+		return ""
+	}
+	if maxSize > 0 && int64(len(content)) > maxSize {
+		s.error(w, r, ErrBodyTooLarge(maxSize), http.StatusRequestEntityTooLarge)
 		return ""
 	}
 
@@ -752,6 +1512,42 @@ func (s *Server) readBody(w http.ResponseWriter, r *http.Request) string {
 	return string(content)
 }
 
+// decodeBody decodes the JSON request body into v, it responds with an error and returns false if the body is invalid or exceeds the max body size.
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	maxSize := s.cfg.MaxBodySize
+	if maxSize > 0 {
+		if r.ContentLength > maxSize {
+			s.error(w, r, ErrBodyTooLarge(maxSize), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.error(w, r, ErrBodyTooLarge(maxSize), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		s.error(w, r, err, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// bodyError responds with 413 Request Entity Too Large if the request body exceeded the max body size, or 400 Bad Request if it couldn't be decompressed.
+func (s *Server) bodyError(w http.ResponseWriter, r *http.Request, err error, maxSize int64) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		s.error(w, r, ErrBodyTooLarge(maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "" {
+		s.error(w, r, fmt.Errorf("invalid compressed request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	s.error(w, r, err, http.StatusInternalServerError)
+}
+
 func (s *Server) redirectRoot(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -761,6 +1557,7 @@ func (s *Server) documentNotFound(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) rateLimit(w http.ResponseWriter, r *http.Request) {
+	s.metrics.RateLimited()
 	s.error(w, r, ErrRateLimit, http.StatusTooManyRequests)
 }
 
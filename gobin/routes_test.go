@@ -0,0 +1,62 @@
+package gobin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	s := &Server{cfg: Config{MaxBodySize: 64}}
+	for _, c := range []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{"valid", `{"query": "needle"}`, 19, http.StatusOK},
+		{"invalid", `{"query": `, 10, http.StatusBadRequest},
+		{"too large", `{"query": "` + strings.Repeat("a", 64) + `"}`, 77, http.StatusRequestEntityTooLarge},
+		// chunked bodies don't announce their size
+		{"too large chunked", `{"query": "` + strings.Repeat("a", 64) + `"}`, -1, http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/documents/search", io.NopCloser(strings.NewReader(c.body)))
+		r.ContentLength = c.contentLength
+		w := httptest.NewRecorder()
+		var searchRequest SearchRequest
+		if s.decodeBody(w, r, &searchRequest) {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != c.want {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.want, w.Code)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		query      string
+		password   string
+		wantHash   bool
+		wantUpdate bool
+		wantErr    bool
+	}{
+		{"no password", "", "", false, false, false},
+		{"set password", "", "secret", true, true, false},
+		{"remove password", "?password=none", "", false, true, false},
+		{"remove and set password", "?password=none", "secret", false, false, true},
+		{"invalid password parameter", "?password=secret", "", false, false, true},
+	} {
+		r := httptest.NewRequest(http.MethodPatch, "/documents/abc"+c.query, nil)
+		if c.password != "" {
+			r.Header.Set(PasswordHeader, c.password)
+		}
+		hash, update, err := hashPassword(r)
+		if (err != nil) != c.wantErr || (hash != nil) != c.wantHash || update != c.wantUpdate {
+			t.Errorf("%s: got hash %v, update %t, error %v", c.name, hash != nil, update, err)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package gobin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	maxSearchLimit  = 100
+	maxSearchTokens = 100
+
+	// searchJoin joins the search index entries as s with the document versions they index.
+	searchJoin = "JOIN documents d ON d.id = s.id AND d.content_hash = s.content_hash JOIN document_contents c ON c.hash = d.content_hash"
+)
+
+var (
+	ErrEmptySearch   = errors.New("query or language is required")
+	ErrTooManyTokens = errors.New("too many tokens, at most 100 are allowed")
+	// ErrContentSearchDisabled is returned for searches with a query while contents are encrypted at rest, as encrypted contents aren't indexed.
+	ErrContentSearchDisabled = errors.New("searching by content is disabled while contents are encrypted at rest, search by language instead")
+)
+
+type (
+	SearchRequest struct {
+		Query    string   `json:"query"`
+		Language string   `json:"language"`
+		Tokens   []string `json:"tokens"`
+		Limit    int      `json:"limit"`
+	}
+	SearchResponse struct {
+		Key       string `json:"key"`
+		Version   int64  `json:"version"`
+		CreatedAt int64  `json:"created_at"`
+		Language  string `json:"language"`
+		Snippet   string `json:"snippet"`
+	}
+)
+
+func (s *Server) PostDocumentSearch(w http.ResponseWriter, r *http.Request) {
+	var searchRequest SearchRequest
+	if !s.decodeBody(w, r, &searchRequest) {
+		return
+	}
+	searchRequest.Query = strings.TrimSpace(searchRequest.Query)
+	if searchRequest.Query == "" && searchRequest.Language == "" {
+		s.error(w, r, ErrEmptySearch, http.StatusBadRequest)
+		return
+	}
+	if len(searchRequest.Tokens) > maxSearchTokens {
+		s.error(w, r, ErrTooManyTokens, http.StatusBadRequest)
+		return
+	}
+
+	ownedIDs, err := s.readableDocumentIDs(r, searchRequest.Tokens)
+	if err != nil {
+		s.log(r, "check search tokens", err)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	results, err := s.db.SearchDocuments(r.Context(), SearchOptions{
+		Query:    searchRequest.Query,
+		Language: searchRequest.Language,
+		OwnedIDs: ownedIDs,
+		Limit:    searchRequest.Limit,
+	})
+	if errors.Is(err, ErrContentSearchDisabled) {
+		s.error(w, r, err, http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		s.log(r, "search documents", err)
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SearchResponse, 0, len(results))
+	for _, result := range results {
+		response = append(response, SearchResponse{
+			Key:       result.ID,
+			Version:   result.Version,
+			CreatedAt: result.CreatedAt,
+			Language:  result.Language,
+			Snippet:   result.Snippet,
+		})
+	}
+	s.ok(w, r, response)
+}
+
+// readableDocumentIDs returns the ids of the documents the request token and the given tokens have the read permission for.
+// Invalid, expired and revoked tokens are ignored, as clients send all tokens they know of.
+func (s *Server) readableDocumentIDs(r *http.Request, tokens []string) ([]string, error) {
+	var documentIDs []string
+	if claims := s.GetClaims(r); claims.Subject != "" && slices.Contains(claims.Permissions, PermissionRead) {
+		documentIDs = append(documentIDs, claims.Subject)
+	}
+
+	now := time.Now()
+	for _, token := range tokens {
+		claims, err := s.parseToken(token)
+		if err != nil || claims.Subject == "" || !slices.Contains(claims.Permissions, PermissionRead) || slices.Contains(documentIDs, claims.Subject) {
+			continue
+		}
+		if claims.Expiry != nil && now.After(claims.Expiry.Time()) {
+			continue
+		}
+		revoked, err := s.db.IsTokenRevoked(r.Context(), claims.Subject, claims.ID, claims.IssuedAt.Time().Unix())
+		if err != nil {
+			return nil, err
+		}
+		if !revoked {
+			documentIDs = append(documentIDs, claims.Subject)
+		}
+	}
+	return documentIDs, nil
+}
+
+type SearchResult struct {
+	ID        string `db:"id"`
+	Version   int64  `db:"version"`
+	CreatedAt int64  `db:"created_at"`
+	Language  string `db:"language"`
+	Snippet   string `db:"snippet"`
+}
+
+// SearchOptions limits the documents a search may return.
+// Only documents in OwnedIDs or public documents which are not private, password protected, view limited or encrypted are searched.
+type SearchOptions struct {
+	Query    string
+	Language string
+	OwnedIDs []string
+	Limit    int
+}
+
+// SearchDocuments searches the latest version of documents by content and language.
+// Results are ordered by relevance if a query is given, otherwise by creation time.
+// Contents encrypted at rest are never indexed, so ErrContentSearchDisabled is returned for queries while new contents are encrypted.
+func (d *DB) SearchDocuments(ctx context.Context, opts SearchOptions) ([]SearchResult, error) {
+	defer d.metrics.ObserveQuery("search_documents", time.Now())
+	if opts.Query != "" && d.encryptionKeyID() != "" {
+		return nil, ErrContentSearchDisabled
+	}
+	if opts.Limit <= 0 || opts.Limit > maxSearchLimit {
+		opts.Limit = maxSearchLimit
+	}
+
+	var (
+		from  string
+		order string
+		where []string
+		args  []any
+	)
+	switch {
+	case opts.Query == "":
+		from = documentsJoin
+		order = "d.created_at DESC"
+	case d.dbType == "postgres":
+		from = "document_search s " + searchJoin + ", websearch_to_tsquery('simple', ?) q"
+		order = "ts_rank(s.search_vector, q) DESC"
+		where = append(where, "s.search_vector @@ q")
+		args = append(args, opts.Query)
+	default:
+		from = "document_search_fts JOIN document_search s ON s.search_id = document_search_fts.rowid " + searchJoin
+		order = "document_search_fts.rank"
+		where = append(where, "document_search_fts MATCH ?")
+		args = append(args, sqliteMatchQuery(opts.Query))
+	}
+
+	where = append(where, "d.version = (SELECT MAX(version) FROM documents WHERE id = d.id)")
+	where = append(where, fmt.Sprintf(notExpiredCondition, "?"))
+	args = append(args, time.Now().Unix())
+	if opts.Language != "" {
+		where = append(where, "LOWER(d.language) = LOWER(?)")
+		args = append(args, opts.Language)
+	}
+
+	// the content of encrypted documents is ciphertext, so searching it is pointless
+	visibility := "(d.public AND NOT d.private AND d.password_hash IS NULL AND d.views_left IS NULL AND NOT d.encrypted)"
+	if len(opts.OwnedIDs) > 0 {
+		owned := "d.id IN (?)"
+		if opts.Query != "" {
+			owned = "(d.id IN (?) AND NOT d.encrypted)"
+		}
+		visibility = "(" + owned + " OR " + visibility + ")"
+		args = append(args, opts.OwnedIDs)
+	}
+	where = append(where, visibility)
+
+	query, args, err := sqlx.In("SELECT "+documentColumns+" FROM "+from+" WHERE "+strings.Join(where, " AND ")+" ORDER BY "+order+" LIMIT ?", append(args, opts.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []Document
+	if err = d.dbx.SelectContext(ctx, &docs, d.dbx.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	// the index doesn't keep the contents, so snippets are taken from the contents of the results
+	terms := searchTerms(opts.Query)
+	results := make([]SearchResult, 0, len(docs))
+	for _, doc := range docs {
+		if err = d.loadContent(ctx, d.dbx, &doc, nil); err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{
+			ID:        doc.ID,
+			Version:   doc.Version,
+			CreatedAt: doc.CreatedAt,
+			Language:  doc.Language,
+			Snippet:   searchSnippet(doc.Content, firstMatch(strings.ToLower(doc.Content), terms)),
+		})
+	}
+	return results, nil
+}
+
+// searchEntry is a row of document_search, SearchID is only set for sqlite.
+type searchEntry struct {
+	ID          string `db:"id"`
+	ContentHash string `db:"content_hash"`
+	SearchID    int64  `db:"search_id"`
+}
+
+// indexableVersion is the latest version of a document with what decides if it's indexed.
+type indexableVersion struct {
+	ID              string  `db:"id"`
+	ContentHash     string  `db:"content_hash"`
+	Encrypted       bool    `db:"encrypted"`
+	EncryptionKeyID *string `db:"encryption_key_id"`
+}
+
+// IndexDocuments adds the latest versions of all documents which are missing from the search index to it and returns the number of indexed documents.
+// Migrations only index contents stored as plain text, this indexes compressed contents and contents in the content storage as well.
+// Documents are indexed in batches with their own transaction, so it can be stopped and run again at any time.
+func (d *DB) IndexDocuments(ctx context.Context) (int64, error) {
+	defer d.metrics.ObserveQuery("index_documents", time.Now())
+	var (
+		indexed int64
+		lastID  string
+	)
+	for {
+		var documentIDs []string
+		if err := d.dbx.SelectContext(ctx, &documentIDs, "SELECT DISTINCT id FROM documents WHERE id > $1 AND id NOT IN (SELECT id FROM document_search) ORDER BY id LIMIT $2", lastID, rewriteBatchSize); err != nil {
+			return indexed, err
+		}
+		if len(documentIDs) == 0 {
+			return indexed, nil
+		}
+
+		n, err := d.indexDocumentBatch(ctx, documentIDs)
+		if err != nil {
+			return indexed, err
+		}
+		indexed += n
+		lastID = documentIDs[len(documentIDs)-1]
+	}
+}
+
+func (d *DB) indexDocumentBatch(ctx context.Context, documentIDs []string) (int64, error) {
+	tx, err := d.beginContentTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.rollback(tx)
+
+	indexed, err := d.indexDocuments(ctx, tx, documentIDs, nil)
+	if err != nil {
+		return 0, err
+	}
+	return indexed, d.commit(tx)
+}
+
+// indexDocuments updates the search index entries of the documents to their latest versions and returns the number of indexed versions.
+// Only latest versions which are neither end-to-end encrypted nor encrypted at rest are indexed, entries of deleted documents are removed.
+// Contents are read from contents by their hash if they are in it, it may be nil.
+func (d *DB) indexDocuments(ctx context.Context, tx *contentTx, documentIDs []string, contents map[string]string) (int64, error) {
+	if contents == nil {
+		contents = map[string]string{}
+	}
+	var indexed int64
+	for _, chunk := range chunkValues(documentIDs) {
+		query, args, err := sqlx.In("SELECT d.id, d.content_hash, d.encrypted, c.encryption_key_id FROM "+documentsJoin+" WHERE d.id IN (?) AND d.version = (SELECT MAX(version) FROM documents WHERE id = d.id)", chunk)
+		if err != nil {
+			return 0, err
+		}
+		var versions []indexableVersion
+		if err = tx.SelectContext(ctx, &versions, tx.Rebind(query), args...); err != nil {
+			return 0, err
+		}
+		latest := make(map[string]indexableVersion, len(versions))
+		for _, version := range versions {
+			if !version.Encrypted && version.EncryptionKeyID == nil {
+				latest[version.ID] = version
+			}
+		}
+
+		columns := "id, content_hash"
+		if d.dbType != "postgres" {
+			columns += ", search_id"
+		}
+		if query, args, err = sqlx.In("SELECT "+columns+" FROM document_search WHERE id IN (?)", chunk); err != nil {
+			return 0, err
+		}
+		var entries []searchEntry
+		if err = tx.SelectContext(ctx, &entries, tx.Rebind(query), args...); err != nil {
+			return 0, err
+		}
+		current := make(map[string]searchEntry, len(entries))
+		for _, entry := range entries {
+			current[entry.ID] = entry
+		}
+
+		for _, documentID := range chunk {
+			entry, isIndexed := current[documentID]
+			version, indexable := latest[documentID]
+			if isIndexed && indexable && entry.ContentHash == version.ContentHash {
+				continue
+			}
+			if isIndexed {
+				if err = d.removeSearchEntry(ctx, tx, entry, contents); err != nil {
+					return 0, err
+				}
+			}
+			if indexable {
+				if err = d.addSearchEntry(ctx, tx, version, contents); err != nil {
+					return 0, err
+				}
+				indexed++
+			}
+		}
+	}
+	return indexed, nil
+}
+
+// indexContentDocuments updates the search index entries of the documents whose latest version has the content of hash.
+func (d *DB) indexContentDocuments(ctx context.Context, tx *contentTx, hash string, content string) error {
+	var documentIDs []string
+	if err := tx.SelectContext(ctx, &documentIDs, "SELECT d.id FROM documents d WHERE d.content_hash = $1 AND d.version = (SELECT MAX(version) FROM documents WHERE id = d.id)", hash); err != nil {
+		return err
+	}
+	_, err := d.indexDocuments(ctx, tx, documentIDs, map[string]string{hash: content})
+	return err
+}
+
+// addSearchEntry indexes the content of the version.
+func (d *DB) addSearchEntry(ctx context.Context, tx *contentTx, version indexableVersion, contents map[string]string) error {
+	content, err := d.getContent(ctx, tx, version.ContentHash, contents)
+	if err != nil {
+		return err
+	}
+	if d.dbType == "postgres" {
+		_, err = tx.ExecContext(ctx, "INSERT INTO document_search (id, content_hash, search_vector) VALUES ($1, $2, to_tsvector('simple', CAST($3 AS TEXT)))", version.ID, version.ContentHash, content)
+		return err
+	}
+	var searchID int64
+	if err = tx.GetContext(ctx, &searchID, "INSERT INTO document_search (id, content_hash) VALUES ($1, $2) RETURNING search_id", version.ID, version.ContentHash); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO document_search_fts (rowid, content) VALUES ($1, $2)", searchID, content)
+	return err
+}
+
+// removeSearchEntry removes the entry from the search index.
+// The contentless FTS5 table of sqlite needs the indexed content to remove it, so the content of the entry has to exist until it's removed.
+func (d *DB) removeSearchEntry(ctx context.Context, tx *contentTx, entry searchEntry, contents map[string]string) error {
+	if d.dbType != "postgres" {
+		content, err := d.getContent(ctx, tx, entry.ContentHash, contents)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, "INSERT INTO document_search_fts (document_search_fts, rowid, content) VALUES ('delete', $1, $2)", entry.SearchID, content); err != nil {
+			return err
+		}
+	}
+	_, err := tx.ExecContext(ctx, "DELETE FROM document_search WHERE id = $1", entry.ID)
+	return err
+}
+
+// sqliteMatchQuery quotes every term of the query, so FTS5 matches documents containing all terms instead of interpreting the query syntax.
+func sqliteMatchQuery(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchTerms splits a query into lower case terms for searchDocument.
+func searchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// searchDocument matches the latest version of a document against the search options for stores without a full-text search index.
+// It follows the same visibility rules as SearchDocuments of the SQL databases, but only matches documents containing all terms case-insensitively.
+func searchDocument(doc Document, opts SearchOptions, terms []string) (SearchResult, bool) {
+	if opts.Language != "" && !strings.EqualFold(doc.Language, opts.Language) {
+		return SearchResult{}, false
+	}
+
+	// the content of encrypted documents is ciphertext, so searching it is pointless
+	owned := slices.Contains(opts.OwnedIDs, doc.ID) && (len(terms) == 0 || !doc.Encrypted)
+	public := doc.Public && !doc.Private && doc.PasswordHash == nil && doc.ViewsLeft == nil && !doc.Encrypted
+	if !owned && !public {
+		return SearchResult{}, false
+	}
+
+	content := strings.ToLower(doc.Content)
+	for _, term := range terms {
+		if !strings.Contains(content, term) {
+			return SearchResult{}, false
+		}
+	}
+	match := firstMatch(content, terms)
+
+	return SearchResult{
+		ID:        doc.ID,
+		Version:   doc.Version,
+		CreatedAt: doc.CreatedAt,
+		Language:  doc.Language,
+		Snippet:   searchSnippet(doc.Content, match),
+	}, true
+}
+
+// firstMatch returns the byte offset of the first term in the lower case content, or 0 if none of the terms is in it.
+func firstMatch(content string, terms []string) int {
+	match := -1
+	for _, term := range terms {
+		if index := strings.Index(content, term); index != -1 && (match == -1 || index < match) {
+			match = index
+		}
+	}
+	if match == -1 {
+		return 0
+	}
+	return match
+}
+
+// searchSnippet returns up to 100 characters of the content starting shortly before the byte offset of the match.
+func searchSnippet(content string, match int) string {
+	start := match - 40
+	if start < 0 {
+		start = 0
+	}
+	if start > len(content) {
+		start = len(content)
+	}
+	for start > 0 && start < len(content) && !utf8.RuneStart(content[start]) {
+		start--
+	}
+
+	runes := []rune(content[start:])
+	var snippet string
+	if start > 0 {
+		snippet = "..."
+	}
+	if len(runes) > 100 {
+		return snippet + string(runes[:100]) + "..."
+	}
+	return snippet + string(runes)
+}
+
+// limitSearchResults orders the results of searchDocument by creation time and applies the search limit.
+func limitSearchResults(results []SearchResult, limit int) []SearchResult {
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].CreatedAt == results[j].CreatedAt {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].CreatedAt > results[j].CreatedAt
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
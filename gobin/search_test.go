@@ -0,0 +1,182 @@
+package gobin
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearchCompressedContents(t *testing.T) {
+	for _, compression := range []string{"gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			db := newTestSQLiteDB(t, DatabaseConfig{Compression: compression})
+			testSearchStoredContents(t, db, func(content documentContent) bool {
+				return content.Compression != nil && *content.Compression == compression
+			})
+		})
+	}
+}
+
+// testSearchStoredContents checks that documents are found by their content and language if their content isn't stored as plain text in the content column.
+// isStored checks that a content is stored that way.
+func testSearchStoredContents(t *testing.T, db *DB, isStored func(content documentContent) bool) {
+	t.Helper()
+	ctx := context.Background()
+
+	// long contents, so they get smaller by compressing them
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 50) + "a needle in the haystack\n"
+	document, err := db.CreateDocument(ctx, Document{Content: content, Language: "plaintext", Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored documentContent
+	if err = db.dbx.GetContext(ctx, &stored, "SELECT "+contentColumns+" FROM document_contents WHERE hash = $1", document.ContentHash); err != nil {
+		t.Fatal(err)
+	}
+	if !isStored(stored) || stored.Content != "" {
+		t.Fatalf("expected the content not to be stored as plain text, got %+v", stored)
+	}
+
+	results, err := db.SearchDocuments(ctx, SearchOptions{Query: "needle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != document.ID || !strings.Contains(results[0].Snippet, "needle") {
+		t.Fatalf("expected document %s with the needle in its snippet, got %+v", document.ID, results)
+	}
+
+	if results, err = db.SearchDocuments(ctx, SearchOptions{Language: "plaintext"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(content, strings.TrimSuffix(results[0].Snippet, "...")) || results[0].Snippet == "" {
+		t.Fatalf("expected document %s with the start of its content as snippet, got %+v", document.ID, results)
+	}
+
+	// the old version becomes a delta, which isn't searched anymore
+	updated, err := db.UpdateDocument(ctx, document.ID, strings.Replace(content, "needle", "pin", 1), "plaintext", 0, DocumentUpdate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexed []string
+	if err = db.dbx.SelectContext(ctx, &indexed, "SELECT content_hash FROM document_search WHERE id = $1", document.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(indexed) != 1 || indexed[0] != updated.ContentHash {
+		t.Fatalf("expected only the latest version %s to be indexed, got %v", updated.ContentHash, indexed)
+	}
+	for query, want := range map[string]int{"needle": 0, "pin": 1} {
+		if results, err = db.SearchDocuments(ctx, SearchOptions{Query: query}); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != want {
+			t.Errorf("expected %d results for %q, got %+v", want, query, results)
+		}
+	}
+
+	// removing deleted documents from the index reads their contents
+	if err = db.DeleteDocument(ctx, document.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = db.SearchDocuments(ctx, SearchOptions{Query: "pin"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results for the deleted document, got %+v", results)
+	}
+}
+
+func TestIndexDocuments(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t, DatabaseConfig{Compression: "zstd"})
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 50)
+	document, err := db.CreateDocument(ctx, Document{Content: content, Language: "plaintext", Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like a compressed content which existed before the search index
+	for _, query := range []string{"DELETE FROM document_search", "INSERT INTO document_search_fts (document_search_fts) VALUES ('delete-all')"} {
+		if _, err = db.dbx.ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexed, err := db.IndexDocuments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 1 {
+		t.Fatalf("expected 1 indexed document, got %d", indexed)
+	}
+	if indexed, err = db.IndexDocuments(ctx); err != nil || indexed != 0 {
+		t.Fatalf("expected no documents to index again, got %d: %v", indexed, err)
+	}
+
+	results, err := db.SearchDocuments(ctx, SearchOptions{Query: "fox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != document.ID {
+		t.Fatalf("expected document %s, got %+v", document.ID, results)
+	}
+}
+
+func TestSearchEncryptedContents(t *testing.T) {
+	ctx := context.Background()
+	cfg := DatabaseConfig{
+		Path: filepath.Join(t.TempDir(), "gobin.db"),
+		Encryption: EncryptionConfig{
+			KeyID: "test",
+			Keys:  map[string]string{"test": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="},
+		},
+	}
+	db := newTestSQLiteDB(t, cfg)
+	document, err := db.CreateDocument(ctx, Document{Content: "a needle in the haystack", Language: "plaintext", Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored documentContent
+	if err = db.dbx.GetContext(ctx, &stored, "SELECT "+contentColumns+" FROM document_contents WHERE hash = $1", document.ContentHash); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content != "" {
+		t.Fatalf("expected no plain text of the encrypted content, got %+v", stored)
+	}
+	var indexed int
+	if err = db.dbx.GetContext(ctx, &indexed, "SELECT COUNT(*) FROM document_search WHERE id = $1", document.ID); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 0 {
+		t.Fatal("expected the encrypted content not to be indexed")
+	}
+
+	if _, err = db.SearchDocuments(ctx, SearchOptions{Query: "needle"}); !errors.Is(err, ErrContentSearchDisabled) {
+		t.Fatalf("expected %s, got %v", ErrContentSearchDisabled, err)
+	}
+	results, err := db.SearchDocuments(ctx, SearchOptions{Language: "plaintext"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != document.ID {
+		t.Fatalf("expected document %s, got %+v", document.ID, results)
+	}
+
+	// decrypted contents are indexed again
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Encryption.KeyID = ""
+	db = newTestSQLiteDB(t, cfg)
+	if _, err = db.ReencryptContents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = db.SearchDocuments(ctx, SearchOptions{Query: "needle"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != document.ID {
+		t.Fatalf("expected document %s, got %+v", document.ID, results)
+	}
+}
@@ -1,6 +1,8 @@
 package gobin
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,14 +16,17 @@ import (
 
 type ExecuteTemplateFunc func(wr io.Writer, name string, data any) error
 
-func NewServer(version string, cfg Config, db *DB, signer jose.Signer, assets http.FileSystem, tmpl ExecuteTemplateFunc) *Server {
+func NewServer(version string, cfg Config, db Store, signer jose.Signer, assets http.FileSystem, tmpl ExecuteTemplateFunc, metrics *Metrics, renderCache *RenderCache) *Server {
 	s := &Server{
-		version: version,
-		cfg:     cfg,
-		db:      db,
-		signer:  signer,
-		assets:  assets,
-		tmpl:    tmpl,
+		version:     version,
+		cfg:         cfg,
+		db:          db,
+		signer:      signer,
+		assets:      assets,
+		tmpl:        tmpl,
+		metrics:     metrics,
+		renderer:    NewRenderer(cfg.Render),
+		renderCache: renderCache,
 	}
 
 	if cfg.RateLimit != nil && cfg.RateLimit.Requests > 0 && cfg.RateLimit.Duration > 0 {
@@ -36,23 +41,87 @@ func NewServer(version string, cfg Config, db *DB, signer jose.Signer, assets ht
 		).Handler
 	}
 
+	s.server = &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: s.Routes(),
+	}
+	if metrics != nil && cfg.Metrics.ListenAddr != "" {
+		// serve the metrics on their own listen address, so they don't have to be exposed publicly
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		s.metricsServer = &http.Server{
+			Addr:    cfg.Metrics.ListenAddr,
+			Handler: mux,
+		}
+	}
+
 	return s
 }
 
 type Server struct {
 	version          string
 	cfg              Config
-	db               *DB
+	db               Store
 	signer           jose.Signer
 	assets           http.FileSystem
 	tmpl             ExecuteTemplateFunc
+	metrics          *Metrics
+	renderer         *Renderer
+	renderCache      *RenderCache
 	rateLimitHandler func(http.Handler) http.Handler
+	server           *http.Server
+	metricsServer    *http.Server
+}
+
+// Start serves requests until ctx is done or a listener fails.
+// The server is then shut down gracefully, waiting up to the configured shutdown timeout for in-flight requests.
+// Start returns nil once the server was shut down by ctx or Shutdown.
+func (s *Server) Start(ctx context.Context) error {
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.server.ListenAndServe()
+	}()
+	if s.metricsServer != nil {
+		go func() {
+			errs <- s.metricsServer.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down gobin...")
+	case err = <-errs:
+		if errors.Is(err, http.ErrServerClosed) {
+			// Shutdown was called directly
+			return nil
+		}
+	}
+
+	shutdownCtx := context.Background()
+	if s.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	return errors.Join(err, s.Shutdown(shutdownCtx))
 }
 
-func (s *Server) Start() {
-	if err := http.ListenAndServe(s.cfg.ListenAddr, s.Routes()); err != nil {
-		log.Fatalln("Error while listening:", err)
+// Shutdown stops accepting new connections and waits for in-flight requests until ctx is done, remaining connections are closed afterwards.
+// It then stops the render workers and the document cleanup and closes the database and the render cache.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := s.server.Shutdown(ctx); err != nil {
+		errs = append(errs, err, s.server.Close())
+	}
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Shutdown(ctx); err != nil {
+			errs = append(errs, err, s.metricsServer.Close())
+		}
 	}
+	s.renderer.Close()
+	errs = append(errs, s.db.Close(), s.renderCache.Close())
+	return errors.Join(errs...)
 }
 
 func FormatBuildVersion(version string, commit string, buildTime string) string {
@@ -0,0 +1,165 @@
+package gobin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+)
+
+// Store persists documents, their versions and token revocations.
+// Versions of a document are numbered from 1 and never reused, even if the latest version is deleted.
+// Methods return sql.ErrNoRows if the document or version they operate on doesn't exist.
+// Documents which expired are not returned by reads and searches, even before DeleteExpiredDocuments deleted them.
+type Store interface {
+	GetDocument(ctx context.Context, documentID string) (Document, error)
+	// GetDocumentVersion returns a version of a document by its number or by its legacy version, see Document.LegacyVersion.
+	GetDocumentVersion(ctx context.Context, documentID string, version int64) (Document, error)
+	// GetDocumentVersions returns all versions of a document ordered from newest to oldest, the content is only included if withContent is true.
+	GetDocumentVersions(ctx context.Context, documentID string, withContent bool) ([]Document, error)
+	GetVersionCount(ctx context.Context, documentID string) (int, error)
+	// CreateDocument creates a new document with a random ID as version 1.
+	CreateDocument(ctx context.Context, document Document) (Document, error)
+	// UpdateDocument creates the next version of a document, it inherits the metadata of the latest version.
+	// The metadata of the update is applied to all versions atomically with creating the new version.
+	// If matchVersion isn't 0, ErrVersionConflict is returned unless it's the latest version of the document.
+	UpdateDocument(ctx context.Context, documentID string, content string, language string, matchVersion int64, update DocumentUpdate) (Document, error)
+	UpdateDocumentExpiration(ctx context.Context, documentID string, expiresAt *int64) error
+	UpdateDocumentPassword(ctx context.Context, documentID string, passwordHash *string) error
+	UpdateDocumentVisibility(ctx context.Context, documentID string, private bool, public bool) error
+	// ViewDocument atomically counts a view of a view limited document and returns the views left.
+	// The document with all its versions is deleted once no views are left.
+	ViewDocument(ctx context.Context, documentID string) (int64, error)
+	// DeleteDocument deletes a document with all its versions.
+	// If matchVersion isn't 0, ErrVersionConflict is returned unless it's the latest version of the document.
+	DeleteDocument(ctx context.Context, documentID string, matchVersion int64) error
+	// DeleteDocumentByVersion deletes a version of a document by its number or by its legacy version.
+	DeleteDocumentByVersion(ctx context.Context, documentID string, version int64) error
+	SearchDocuments(ctx context.Context, opts SearchOptions) ([]SearchResult, error)
+
+	RevokeToken(ctx context.Context, documentID string, tokenID string, expiresAt *int64) error
+	RevokeAllTokens(ctx context.Context, documentID string, revokedAt int64) error
+	IsTokenRevoked(ctx context.Context, documentID string, tokenID string, issuedAt int64) (bool, error)
+
+	// DeleteExpiredDocuments deletes all documents which expired and returns the number of deleted versions.
+	// Documents without an expiration are deleted expireAfter after their version was created, if expireAfter is greater than 0.
+	DeleteExpiredDocuments(ctx context.Context, expireAfter time.Duration) (int64, error)
+	// DeleteExpiredTokenRevocations deletes revocations of tokens which expired or belong to deleted documents and returns the number of deleted revocations.
+	DeleteExpiredTokenRevocations(ctx context.Context) (int64, error)
+
+	// Close stops the cleanup and releases all resources of the store, it is safe to call Close multiple times.
+	Close() error
+}
+
+// expired checks if the expiration of the document passed.
+func expired(doc Document, now time.Time) bool {
+	return doc.ExpiresAt != nil && *doc.ExpiresAt <= now.Unix()
+}
+
+// ErrVersionConflict is returned when a document is changed based on a version which is no longer its latest version.
+var ErrVersionConflict = errors.New("document has been changed")
+
+// NewDB creates the Store of the configured database type and starts its cleanup.
+// The migrations are only used by the SQL databases, see LoadMigrations.
+func NewDB(ctx context.Context, cfg DatabaseConfig, migrations fs.FS, metrics *Metrics) (Store, error) {
+	if cfg.ContentStorage.Type != "" && cfg.Type != "postgres" && cfg.Type != "sqlite" {
+		return nil, errors.New("content storage is only supported by postgres and sqlite")
+	}
+	if cfg.Compression != "" && cfg.Type != "postgres" && cfg.Type != "sqlite" {
+		return nil, errors.New("compression is only supported by postgres and sqlite")
+	}
+	if cfg.Encryption.Enabled() && cfg.Type != "postgres" && cfg.Type != "sqlite" {
+		return nil, errors.New("encryption is only supported by postgres and sqlite")
+	}
+	switch cfg.Type {
+	case "postgres", "sqlite":
+		return NewSQLDB(ctx, cfg, migrations, metrics)
+	case "bbolt":
+		return NewBoltDB(cfg, metrics)
+	case "memory":
+		return NewMemoryDB(cfg, metrics), nil
+	default:
+		return nil, errors.New("invalid database type, must be one of: postgres, sqlite, bbolt, memory")
+	}
+}
+
+// contentHash returns the hex encoded SHA-256 hash of the content, which identifies it across document versions.
+func contentHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// versionIndex returns the index of the version in the versions of a document or -1.
+// Like GetDocumentVersion it matches the number of a version before legacy versions.
+func versionIndex(versions []Document, version int64) int {
+	legacy := -1
+	for i, doc := range versions {
+		if doc.Version == version {
+			return i
+		}
+		if legacy == -1 && doc.LegacyVersion != nil && *doc.LegacyVersion == version {
+			legacy = i
+		}
+	}
+	return legacy
+}
+
+// startCleanup periodically deletes expired documents and token revocations from the store until it's stopped.
+func startCleanup(store Store, cfg DatabaseConfig, metrics *Metrics) *cleanupLoop {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cleanupLoop{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go c.run(ctx, store, cfg.CleanupInterval, cfg.ExpireAfter, metrics)
+	return c
+}
+
+type cleanupLoop struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop stops the cleanup and waits for a running cleanup to finish.
+func (c *cleanupLoop) stop() {
+	c.cancel()
+	<-c.done
+}
+
+func (c *cleanupLoop) run(ctx context.Context, store Store, cleanUpInterval time.Duration, expireAfter time.Duration, metrics *Metrics) {
+	defer close(c.done)
+	if cleanUpInterval <= 0 {
+		cleanUpInterval = 10 * time.Minute
+	}
+	log.Println("Starting document cleanup...")
+	ticker := time.NewTicker(cleanUpInterval)
+	defer ticker.Stop()
+	defer log.Println("document cleanup stopped")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rows, err := store.DeleteExpiredDocuments(ctx, expireAfter)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.Println("failed to delete expired documents:", err)
+			}
+			metrics.CleanupDeleted("documents", rows)
+			rows, err = store.DeleteExpiredTokenRevocations(ctx)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.Println("failed to delete expired token revocations:", err)
+			}
+			metrics.CleanupDeleted("revoked_tokens", rows)
+		}
+	}
+}
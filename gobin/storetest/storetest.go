@@ -0,0 +1,892 @@
+// Package storetest implements a conformance suite for implementations of gobin.Store.
+// Every backend has to behave exactly like the SQL databases, so handlers don't need to know which one they are using.
+package storetest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/topisenpai/gobin/gobin"
+)
+
+// TestStore runs the conformance suite against stores created by newStore, every check is a subtest named after it.
+// Every check gets a new empty store which is closed afterwards. The cleanup of the stores should be disabled by setting a long cleanup interval.
+// newStore should fail the test if it can't create the store.
+// As versions are deleted by the second they were created at, the suite waits for the next second a couple of times and takes a few seconds to complete.
+func TestStore(t *testing.T, newStore func(t *testing.T) gobin.Store) {
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			store := newStore(t)
+			defer func() {
+				if err := store.Close(); err != nil {
+					t.Errorf("close store: %s", err)
+				}
+			}()
+			if err := c.fn(context.Background(), store); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+type check struct {
+	name string
+	fn   func(ctx context.Context, store gobin.Store) error
+}
+
+var checks = []check{
+	{"create document", checkCreateDocument},
+	{"unique document ids", checkUniqueDocumentIDs},
+	{"not found", checkNotFound},
+	{"update document", checkUpdateDocument},
+	{"version numbers", checkVersionNumbers},
+	{"version conflicts", checkVersionConflicts},
+	{"update metadata", checkUpdateMetadata},
+	{"update document metadata", checkUpdateDocumentMetadata},
+	{"view document", checkViewDocument},
+	{"delete document", checkDeleteDocument},
+	{"delete document version", checkDeleteDocumentVersion},
+	{"shared content", checkSharedContent},
+	{"revoke tokens", checkRevokeTokens},
+	{"expired documents", checkExpiredDocuments},
+	{"delete expired documents", checkDeleteExpiredDocuments},
+	{"delete expired token revocations", checkDeleteExpiredTokenRevocations},
+	{"search documents", checkSearchDocuments},
+	{"close", checkClose},
+}
+
+func checkCreateDocument(ctx context.Context, store gobin.Store) error {
+	before := time.Now().Unix()
+	doc, err := store.CreateDocument(ctx, gobin.Document{
+		Content:      "hello world",
+		Language:     "Go",
+		ExpiresAt:    ptr(time.Now().Add(time.Hour).Unix()),
+		ViewsLeft:    ptr(int64(3)),
+		PasswordHash: ptr("hash"),
+		Encrypted:    true,
+		Private:      true,
+	})
+	if err != nil {
+		return err
+	}
+	if len(doc.ID) != 8 {
+		return fmt.Errorf("expected an id with 8 characters, got %q", doc.ID)
+	}
+	if doc.Version != 1 {
+		return fmt.Errorf("expected version 1, got %d", doc.Version)
+	}
+	if doc.CreatedAt < before || doc.CreatedAt > time.Now().Unix() {
+		return fmt.Errorf("expected the current time as creation time, got %d", doc.CreatedAt)
+	}
+
+	got, err := store.GetDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if err = equalDocuments(doc, got); err != nil {
+		return fmt.Errorf("get document: %w", err)
+	}
+	got, err = store.GetDocumentVersion(ctx, doc.ID, doc.Version)
+	if err != nil {
+		return err
+	}
+	return equalDocuments(doc, got)
+}
+
+func checkUniqueDocumentIDs(ctx context.Context, store gobin.Store) error {
+	ids := map[string]struct{}{}
+	for i := 0; i < 100; i++ {
+		doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+		if err != nil {
+			return err
+		}
+		if _, ok := ids[doc.ID]; ok {
+			return fmt.Errorf("duplicate document id %q", doc.ID)
+		}
+		ids[doc.ID] = struct{}{}
+	}
+	return nil
+}
+
+func checkNotFound(ctx context.Context, store gobin.Store) error {
+	const id = "notfound"
+	var errs []error
+	notFound := func(name string, err error) {
+		if !errors.Is(err, sql.ErrNoRows) {
+			errs = append(errs, fmt.Errorf("%s: expected sql.ErrNoRows, got %v", name, err))
+		}
+	}
+
+	_, err := store.GetDocument(ctx, id)
+	notFound("get document", err)
+	_, err = store.GetDocumentVersion(ctx, id, 1)
+	notFound("get document version", err)
+	_, err = store.UpdateDocument(ctx, id, "hello", "plaintext", 0, gobin.DocumentUpdate{})
+	notFound("update document", err)
+	notFound("update document expiration", store.UpdateDocumentExpiration(ctx, id, nil))
+	notFound("update document password", store.UpdateDocumentPassword(ctx, id, nil))
+	notFound("update document visibility", store.UpdateDocumentVisibility(ctx, id, true, false))
+	_, err = store.ViewDocument(ctx, id)
+	notFound("view document", err)
+	notFound("delete document", store.DeleteDocument(ctx, id, 0))
+	notFound("delete document version", store.DeleteDocumentByVersion(ctx, id, 1))
+
+	versions, err := store.GetDocumentVersions(ctx, id, true)
+	if err != nil || len(versions) != 0 {
+		errs = append(errs, fmt.Errorf("get document versions: expected no versions, got %d and %v", len(versions), err))
+	}
+	count, err := store.GetVersionCount(ctx, id)
+	if err != nil || count != 0 {
+		errs = append(errs, fmt.Errorf("get version count: expected 0, got %d and %v", count, err))
+	}
+
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	_, err = store.GetDocumentVersion(ctx, doc.ID, doc.Version+1)
+	notFound("get missing version of existing document", err)
+	notFound("delete missing version of existing document", store.DeleteDocumentByVersion(ctx, doc.ID, doc.Version+1))
+
+	return errors.Join(errs...)
+}
+
+func checkUpdateDocument(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{
+		Content:      "hello",
+		Language:     "plaintext",
+		ExpiresAt:    ptr(time.Now().Add(time.Hour).Unix()),
+		PasswordHash: ptr("hash"),
+		Private:      true,
+	})
+	if err != nil {
+		return err
+	}
+
+	updated, err := store.UpdateDocument(ctx, doc.ID, "hello world", "Go", 0, gobin.DocumentUpdate{})
+	if err != nil {
+		return err
+	}
+	if updated.Version != doc.Version+1 {
+		return fmt.Errorf("expected version %d, got %d", doc.Version+1, updated.Version)
+	}
+	if updated.CreatedAt < doc.CreatedAt {
+		return fmt.Errorf("expected a creation time after %d, got %d", doc.CreatedAt, updated.CreatedAt)
+	}
+	// the new version inherits the metadata of the latest version
+	want := doc
+	want.Version = updated.Version
+	want.CreatedAt = updated.CreatedAt
+	want.Content = "hello world"
+	want.ContentHash = contentHash("hello world")
+	want.Language = "Go"
+	if err = equalDocuments(want, updated); err != nil {
+		return fmt.Errorf("update document: %w", err)
+	}
+
+	latest, err := store.GetDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if err = equalDocuments(updated, latest); err != nil {
+		return fmt.Errorf("get latest version: %w", err)
+	}
+	first, err := store.GetDocumentVersion(ctx, doc.ID, doc.Version)
+	if err != nil {
+		return err
+	}
+	if err = equalDocuments(doc, first); err != nil {
+		return fmt.Errorf("get first version: %w", err)
+	}
+
+	versions, err := store.GetDocumentVersions(ctx, doc.ID, true)
+	if err != nil {
+		return err
+	}
+	if len(versions) != 2 || versions[0].Version != updated.Version || versions[1].Version != doc.Version {
+		return fmt.Errorf("expected versions %d and %d ordered from newest to oldest, got %v", updated.Version, doc.Version, documentVersions(versions))
+	}
+	if versions[0].Content != "hello world" {
+		return fmt.Errorf("expected versions with content, got %q", versions[0].Content)
+	}
+	versions, err = store.GetDocumentVersions(ctx, doc.ID, false)
+	if err != nil {
+		return err
+	}
+	if len(versions) != 2 || versions[0].Content != "" {
+		return fmt.Errorf("expected 2 versions without content, got %d", len(versions))
+	}
+
+	count, err := store.GetVersionCount(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if count != 2 {
+		return fmt.Errorf("expected 2 versions, got %d", count)
+	}
+	return nil
+}
+
+func checkVersionNumbers(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "1", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	// versions created in the same second get their own numbers
+	for i := int64(2); i <= 3; i++ {
+		updated, err := store.UpdateDocument(ctx, doc.ID, strconv.FormatInt(i, 10), "plaintext", 0, gobin.DocumentUpdate{})
+		if err != nil {
+			return err
+		}
+		if updated.Version != i {
+			return fmt.Errorf("expected version %d, got %d", i, updated.Version)
+		}
+	}
+	for i := int64(1); i <= 3; i++ {
+		got, err := store.GetDocumentVersion(ctx, doc.ID, i)
+		if err != nil {
+			return err
+		}
+		if got.Content != strconv.FormatInt(i, 10) {
+			return fmt.Errorf("expected version %d to have content %q, got %q", i, strconv.FormatInt(i, 10), got.Content)
+		}
+	}
+
+	// the numbers of deleted versions are not reused
+	if err = store.DeleteDocumentByVersion(ctx, doc.ID, 3); err != nil {
+		return err
+	}
+	updated, err := store.UpdateDocument(ctx, doc.ID, "4", "plaintext", 0, gobin.DocumentUpdate{})
+	if err != nil {
+		return err
+	}
+	if updated.Version != 4 {
+		return fmt.Errorf("expected version 4 after deleting version 3, got %d", updated.Version)
+	}
+	versions, err := store.GetDocumentVersions(ctx, doc.ID, false)
+	if err != nil {
+		return err
+	}
+	if got := documentVersions(versions); len(got) != 3 || got[0] != 4 || got[1] != 2 || got[2] != 1 {
+		return fmt.Errorf("expected versions [4 2 1], got %v", got)
+	}
+	return nil
+}
+
+func checkVersionConflicts(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	updated, err := store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", doc.Version, gobin.DocumentUpdate{})
+	if err != nil {
+		return fmt.Errorf("update based on the latest version: %w", err)
+	}
+
+	// changes based on an older version must not overwrite the latest version
+	if _, err = store.UpdateDocument(ctx, doc.ID, "goodbye", "plaintext", doc.Version, gobin.DocumentUpdate{}); !errors.Is(err, gobin.ErrVersionConflict) {
+		return fmt.Errorf("update based on an old version: expected gobin.ErrVersionConflict, got %v", err)
+	}
+	if err = store.DeleteDocument(ctx, doc.ID, doc.Version); !errors.Is(err, gobin.ErrVersionConflict) {
+		return fmt.Errorf("delete based on an old version: expected gobin.ErrVersionConflict, got %v", err)
+	}
+	latest, err := store.GetDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if err = equalDocuments(updated, latest); err != nil {
+		return fmt.Errorf("expected conflicting changes to keep the latest version: %w", err)
+	}
+
+	if err = store.DeleteDocument(ctx, doc.ID, updated.Version); err != nil {
+		return fmt.Errorf("delete based on the latest version: %w", err)
+	}
+	if _, err = store.GetDocument(ctx, doc.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("expected document to be deleted, got %v", err)
+	}
+	return nil
+}
+
+func checkUpdateMetadata(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	if _, err = store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", 0, gobin.DocumentUpdate{}); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	if err = store.UpdateDocumentExpiration(ctx, doc.ID, &expiresAt); err != nil {
+		return err
+	}
+	if err = store.UpdateDocumentPassword(ctx, doc.ID, ptr("hash")); err != nil {
+		return err
+	}
+	if err = store.UpdateDocumentVisibility(ctx, doc.ID, false, true); err != nil {
+		return err
+	}
+
+	// metadata is shared by all versions
+	versions, err := store.GetDocumentVersions(ctx, doc.ID, false)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.ExpiresAt == nil || *version.ExpiresAt != expiresAt || version.PasswordHash == nil || *version.PasswordHash != "hash" || version.Private || !version.Public {
+			return fmt.Errorf("expected version %d to have the updated metadata", version.Version)
+		}
+	}
+
+	if err = store.UpdateDocumentExpiration(ctx, doc.ID, nil); err != nil {
+		return err
+	}
+	if err = store.UpdateDocumentPassword(ctx, doc.ID, nil); err != nil {
+		return err
+	}
+	latest, err := store.GetDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if latest.ExpiresAt != nil || latest.PasswordHash != nil {
+		return errors.New("expected the expiration and password to be removed")
+	}
+	return nil
+}
+
+func checkUpdateDocumentMetadata(ctx context.Context, store gobin.Store) error {
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext", ExpiresAt: &expiresAt, Public: true})
+	if err != nil {
+		return err
+	}
+
+	// only the metadata which is flagged is updated, together with the new version
+	updated, err := store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", 0, gobin.DocumentUpdate{
+		UpdatePassword:   true,
+		PasswordHash:     ptr("hash"),
+		UpdateVisibility: true,
+		Private:          true,
+	})
+	if err != nil {
+		return err
+	}
+	versions, err := store.GetDocumentVersions(ctx, doc.ID, false)
+	if err != nil {
+		return err
+	}
+	for _, version := range append(versions, updated) {
+		if version.ExpiresAt == nil || *version.ExpiresAt != expiresAt || version.PasswordHash == nil || *version.PasswordHash != "hash" || !version.Private || version.Public {
+			return fmt.Errorf("expected version %d to have the updated metadata", version.Version)
+		}
+	}
+
+	if updated, err = store.UpdateDocument(ctx, doc.ID, "goodbye", "plaintext", 0, gobin.DocumentUpdate{UpdateExpiresAt: true, UpdatePassword: true}); err != nil {
+		return err
+	}
+	if updated.ExpiresAt != nil || updated.PasswordHash != nil || !updated.Private {
+		return errors.New("expected the expiration and password to be removed and the visibility to be kept")
+	}
+	return nil
+}
+
+func checkViewDocument(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext", ViewsLeft: ptr(int64(2))})
+	if err != nil {
+		return err
+	}
+
+	viewsLeft, err := store.ViewDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if viewsLeft != 1 {
+		return fmt.Errorf("expected 1 view left, got %d", viewsLeft)
+	}
+	got, err := store.GetDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if got.ViewsLeft == nil || *got.ViewsLeft != 1 {
+		return errors.New("expected the document to have 1 view left")
+	}
+
+	// the last view deletes the document
+	if viewsLeft, err = store.ViewDocument(ctx, doc.ID); err != nil {
+		return err
+	}
+	if viewsLeft != 0 {
+		return fmt.Errorf("expected 0 views left, got %d", viewsLeft)
+	}
+	if _, err = store.GetDocument(ctx, doc.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("expected the document to be deleted after the last view, got %v", err)
+	}
+
+	unlimited, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	if _, err = store.ViewDocument(ctx, unlimited.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("expected sql.ErrNoRows when viewing a document without view limit, got %v", err)
+	}
+	return nil
+}
+
+func checkDeleteDocument(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	other, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	if _, err = store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", 0, gobin.DocumentUpdate{}); err != nil {
+		return err
+	}
+
+	if err = store.DeleteDocument(ctx, doc.ID, 0); err != nil {
+		return err
+	}
+	count, err := store.GetVersionCount(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("expected all versions to be deleted, got %d", count)
+	}
+	if _, err = store.GetDocument(ctx, other.ID); err != nil {
+		return fmt.Errorf("expected other documents to be kept, got %w", err)
+	}
+	return nil
+}
+
+func checkDeleteDocumentVersion(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	updated, err := store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", 0, gobin.DocumentUpdate{})
+	if err != nil {
+		return err
+	}
+
+	if err = store.DeleteDocumentByVersion(ctx, doc.ID, doc.Version); err != nil {
+		return err
+	}
+	versions, err := store.GetDocumentVersions(ctx, doc.ID, false)
+	if err != nil {
+		return err
+	}
+	if len(versions) != 1 || versions[0].Version != updated.Version {
+		return fmt.Errorf("expected only version %d to be left, got %v", updated.Version, documentVersions(versions))
+	}
+
+	// deleting the last version deletes the document
+	if err = store.DeleteDocumentByVersion(ctx, doc.ID, updated.Version); err != nil {
+		return err
+	}
+	if _, err = store.GetDocument(ctx, doc.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("expected the document to be deleted with its last version, got %v", err)
+	}
+	return nil
+}
+
+func checkSharedContent(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	other, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	if doc.ContentHash != contentHash("hello") || other.ContentHash != doc.ContentHash {
+		return fmt.Errorf("expected documents with the same content to have the SHA-256 hash of it, got %q and %q", doc.ContentHash, other.ContentHash)
+	}
+	updated, err := store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", 0, gobin.DocumentUpdate{})
+	if err != nil {
+		return err
+	}
+	if updated.ContentHash == doc.ContentHash {
+		return fmt.Errorf("expected a different content hash for different content, got %q", updated.ContentHash)
+	}
+	reverted, err := store.UpdateDocument(ctx, doc.ID, "hello", "plaintext", 0, gobin.DocumentUpdate{})
+	if err != nil {
+		return err
+	}
+	if reverted.ContentHash != doc.ContentHash {
+		return fmt.Errorf("expected the reverted version to have content hash %q, got %q", doc.ContentHash, reverted.ContentHash)
+	}
+
+	// deleting versions must keep the content of versions which share it
+	if err = store.DeleteDocumentByVersion(ctx, doc.ID, doc.Version); err != nil {
+		return err
+	}
+	got, err := store.GetDocumentVersion(ctx, doc.ID, reverted.Version)
+	if err != nil {
+		return err
+	}
+	if err = equalDocuments(reverted, got); err != nil {
+		return fmt.Errorf("get reverted version: %w", err)
+	}
+	if err = store.DeleteDocument(ctx, doc.ID, 0); err != nil {
+		return err
+	}
+	got, err = store.GetDocument(ctx, other.ID)
+	if err != nil {
+		return err
+	}
+	if err = equalDocuments(other, got); err != nil {
+		return fmt.Errorf("get other document: %w", err)
+	}
+
+	// content which was deleted can be stored again
+	if err = store.DeleteDocument(ctx, other.ID, 0); err != nil {
+		return err
+	}
+	doc, err = store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	got, err = store.GetDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	return equalDocuments(doc, got)
+}
+
+func checkRevokeTokens(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+
+	if err = store.RevokeToken(ctx, doc.ID, "a", nil); err != nil {
+		return err
+	}
+	// revoking a token twice is allowed
+	if err = store.RevokeToken(ctx, doc.ID, "a", nil); err != nil {
+		return err
+	}
+	if err = expectRevoked(ctx, store, doc.ID, "a", 0, true); err != nil {
+		return err
+	}
+	if err = expectRevoked(ctx, store, doc.ID, "b", 0, false); err != nil {
+		return err
+	}
+	if err = expectRevoked(ctx, store, "other", "a", 0, false); err != nil {
+		return err
+	}
+
+	// revoking all tokens only revokes tokens issued before
+	if err = store.RevokeAllTokens(ctx, doc.ID, 100); err != nil {
+		return err
+	}
+	if err = expectRevoked(ctx, store, doc.ID, "b", 99, true); err != nil {
+		return err
+	}
+	if err = expectRevoked(ctx, store, doc.ID, "b", 100, false); err != nil {
+		return err
+	}
+	if err = store.RevokeAllTokens(ctx, doc.ID, 200); err != nil {
+		return err
+	}
+	return expectRevoked(ctx, store, doc.ID, "b", 100, true)
+}
+
+func checkExpiredDocuments(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello world", Language: "plaintext", Public: true})
+	if err != nil {
+		return err
+	}
+	if _, err = store.UpdateDocument(ctx, doc.ID, "hello there", "plaintext", 0, gobin.DocumentUpdate{}); err != nil {
+		return err
+	}
+	// the cleanup hasn't run yet, but the document is gone for readers
+	if err = store.UpdateDocumentExpiration(ctx, doc.ID, ptr(time.Now().Add(-time.Minute).Unix())); err != nil {
+		return err
+	}
+
+	if _, err = store.GetDocument(ctx, doc.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("get document: expected %v, got %v", sql.ErrNoRows, err)
+	}
+	if _, err = store.GetDocumentVersion(ctx, doc.ID, doc.Version); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("get document version: expected %v, got %v", sql.ErrNoRows, err)
+	}
+	for _, withContent := range []bool{true, false} {
+		versions, err := store.GetDocumentVersions(ctx, doc.ID, withContent)
+		if err != nil {
+			return err
+		}
+		if len(versions) != 0 {
+			return fmt.Errorf("get document versions with content %t: expected no versions, got %d", withContent, len(versions))
+		}
+	}
+	for _, opts := range []gobin.SearchOptions{{Query: "hello"}, {Language: "plaintext", OwnedIDs: []string{doc.ID}}} {
+		results, err := store.SearchDocuments(ctx, opts)
+		if errors.Is(err, gobin.ErrContentSearchDisabled) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(results) != 0 {
+			return fmt.Errorf("search %+v: expected no results, got %v", opts, results)
+		}
+	}
+	return nil
+}
+
+func checkDeleteExpiredDocuments(ctx context.Context, store gobin.Store) error {
+	now := time.Now()
+	expired, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext", ExpiresAt: ptr(now.Add(-time.Minute).Unix())})
+	if err != nil {
+		return err
+	}
+	expiring, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext", ExpiresAt: ptr(now.Add(time.Hour).Unix())})
+	if err != nil {
+		return err
+	}
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+
+	deleted, err := store.DeleteExpiredDocuments(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if deleted != 1 {
+		return fmt.Errorf("expected 1 deleted version, got %d", deleted)
+	}
+	if _, err = store.GetDocument(ctx, expired.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("expected the expired document to be deleted, got %v", err)
+	}
+
+	// documents without expiration are deleted expireAfter after their version was created
+	waitNextSecond()
+	waitNextSecond()
+	if _, err = store.UpdateDocument(ctx, doc.ID, "hello world", "plaintext", 0, gobin.DocumentUpdate{}); err != nil {
+		return err
+	}
+	if deleted, err = store.DeleteExpiredDocuments(ctx, time.Second); err != nil {
+		return err
+	}
+	if deleted != 1 {
+		return fmt.Errorf("expected 1 deleted version after expireAfter, got %d", deleted)
+	}
+	count, err := store.GetVersionCount(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("expected only the new version to be left, got %d versions", count)
+	}
+	if _, err = store.GetDocument(ctx, expiring.ID); err != nil {
+		return fmt.Errorf("expected documents with an expiration to be kept, got %w", err)
+	}
+	return nil
+}
+
+func checkDeleteExpiredTokenRevocations(ctx context.Context, store gobin.Store) error {
+	doc, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext"})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err = store.RevokeToken(ctx, doc.ID, "expired", ptr(now.Add(-time.Minute).Unix())); err != nil {
+		return err
+	}
+	if err = store.RevokeToken(ctx, doc.ID, "expiring", ptr(now.Add(time.Hour).Unix())); err != nil {
+		return err
+	}
+	if err = store.RevokeToken(ctx, doc.ID, "forever", nil); err != nil {
+		return err
+	}
+	if err = store.RevokeToken(ctx, "deleted", "forever", nil); err != nil {
+		return err
+	}
+
+	deleted, err := store.DeleteExpiredTokenRevocations(ctx)
+	if err != nil {
+		return err
+	}
+	if deleted != 2 {
+		return fmt.Errorf("expected 2 deleted revocations, got %d", deleted)
+	}
+	if err = expectRevoked(ctx, store, doc.ID, "expiring", 0, true); err != nil {
+		return err
+	}
+	if err = expectRevoked(ctx, store, doc.ID, "forever", 0, true); err != nil {
+		return err
+	}
+	return expectRevoked(ctx, store, "deleted", "forever", 0, false)
+}
+
+func checkSearchDocuments(ctx context.Context, store gobin.Store) error {
+	public, err := store.CreateDocument(ctx, gobin.Document{Content: "hello world", Language: "Go", Public: true})
+	if err != nil {
+		return err
+	}
+	owned, err := store.CreateDocument(ctx, gobin.Document{Content: "hello there", Language: "plaintext", Private: true})
+	if err != nil {
+		return err
+	}
+	encrypted, err := store.CreateDocument(ctx, gobin.Document{Content: "hello", Language: "plaintext", Encrypted: true})
+	if err != nil {
+		return err
+	}
+	// these are never returned to others
+	for _, doc := range []gobin.Document{
+		{Content: "hello", Language: "Go", Public: true, PasswordHash: ptr("hash")},
+		{Content: "hello", Language: "Go", Public: true, ViewsLeft: ptr(int64(1))},
+		{Content: "hello", Language: "Go", Public: true, Encrypted: true},
+		{Content: "hello", Language: "Go"},
+	} {
+		if _, err = store.CreateDocument(ctx, doc); err != nil {
+			return err
+		}
+	}
+
+	ownedIDs := []string{owned.ID, encrypted.ID}
+	for _, c := range []struct {
+		name string
+		opts gobin.SearchOptions
+		want []string
+	}{
+		{"query", gobin.SearchOptions{Query: "hello"}, []string{public.ID}},
+		{"query with owned documents", gobin.SearchOptions{Query: "HELLO", OwnedIDs: ownedIDs}, []string{public.ID, owned.ID}},
+		{"all terms", gobin.SearchOptions{Query: "hello there", OwnedIDs: ownedIDs}, []string{owned.ID}},
+		{"no match", gobin.SearchOptions{Query: "goodbye", OwnedIDs: ownedIDs}, nil},
+		{"language", gobin.SearchOptions{Language: "go"}, []string{public.ID}},
+		{"language with owned documents", gobin.SearchOptions{Language: "plaintext", OwnedIDs: ownedIDs}, []string{owned.ID, encrypted.ID}},
+		{"query and language", gobin.SearchOptions{Query: "hello", Language: "plaintext", OwnedIDs: ownedIDs}, []string{owned.ID}},
+	} {
+		results, err := store.SearchDocuments(ctx, c.opts)
+		if c.opts.Query != "" && errors.Is(err, gobin.ErrContentSearchDisabled) {
+			// stores encrypting contents at rest only search by language
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+		ids := make([]string, 0, len(results))
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+		if !equalIDs(ids, c.want) {
+			return fmt.Errorf("%s: expected documents %v, got %v", c.name, c.want, ids)
+		}
+	}
+
+	results, err := store.SearchDocuments(ctx, gobin.SearchOptions{Query: "hello", OwnedIDs: ownedIDs, Limit: 1})
+	if errors.Is(err, gobin.ErrContentSearchDisabled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 {
+		return fmt.Errorf("expected 1 result with limit 1, got %d", len(results))
+	}
+
+	// only the latest version is searched
+	updated, err := store.UpdateDocument(ctx, public.ID, "goodbye world", "Go", 0, gobin.DocumentUpdate{})
+	if err != nil {
+		return err
+	}
+	if results, err = store.SearchDocuments(ctx, gobin.SearchOptions{Query: "hello"}); err != nil {
+		return err
+	}
+	if len(results) != 0 {
+		return fmt.Errorf("expected old versions not to be searched, got %d results", len(results))
+	}
+	if results, err = store.SearchDocuments(ctx, gobin.SearchOptions{Query: "goodbye"}); err != nil {
+		return err
+	}
+	if len(results) != 1 || results[0].Version != updated.Version || results[0].Language != "Go" {
+		return fmt.Errorf("expected the latest version %d, got %v", updated.Version, results)
+	}
+	return nil
+}
+
+func checkClose(_ context.Context, store gobin.Store) error {
+	// TestStore closes the store again, which has to be allowed
+	return store.Close()
+}
+
+func expectRevoked(ctx context.Context, store gobin.Store, documentID string, tokenID string, issuedAt int64, want bool) error {
+	revoked, err := store.IsTokenRevoked(ctx, documentID, tokenID, issuedAt)
+	if err != nil {
+		return err
+	}
+	if revoked != want {
+		return fmt.Errorf("expected token %q of document %q issued at %d to be revoked: %t, got %t", tokenID, documentID, issuedAt, want, revoked)
+	}
+	return nil
+}
+
+func equalDocuments(want gobin.Document, got gobin.Document) error {
+	if want.ID != got.ID || want.Version != got.Version || want.CreatedAt != got.CreatedAt || want.Content != got.Content || want.ContentHash != got.ContentHash || want.Language != got.Language || want.Encrypted != got.Encrypted || want.Private != got.Private || want.Public != got.Public {
+		return fmt.Errorf("expected document %+v, got %+v", want, got)
+	}
+	if !equalPtr(want.ExpiresAt, got.ExpiresAt) || !equalPtr(want.ViewsLeft, got.ViewsLeft) || !equalPtr(want.PasswordHash, got.PasswordHash) {
+		return fmt.Errorf("expected the expiration, views left and password of document %q to match", want.ID)
+	}
+	return nil
+}
+
+func contentHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func equalIDs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPtr[T comparable](a *T, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func documentVersions(docs []gobin.Document) []int64 {
+	versions := make([]int64, 0, len(docs))
+	for _, doc := range docs {
+		versions = append(versions, doc.Version)
+	}
+	return versions
+}
+
+// waitNextSecond waits until the next unix second, so a new document version gets a later creation time.
+func waitNextSecond() {
+	now := time.Now()
+	time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
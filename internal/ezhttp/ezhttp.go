@@ -16,32 +16,57 @@ var defaultClient = &http.Client{
 	Timeout: 10 * time.Second,
 }
 
-func Do(method string, path string, token string, body io.Reader) (*http.Response, error) {
+func Do(method string, path string, token string, password string, body io.Reader) (*http.Response, error) {
+	return DoWithHeader(method, path, token, password, nil, body)
+}
+
+// DoWithHeader is like Do, but also sends the given header.
+func DoWithHeader(method string, path string, token string, password string, header http.Header, body io.Reader) (*http.Response, error) {
 	server := viper.GetString("server")
 	request, err := http.NewRequest(method, server+path, body)
 	if err != nil {
 		return nil, err
 	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
 	if token != "" {
 		request.Header.Set("Authorization", "Bearer "+token)
 	}
+	if password != "" {
+		request.Header.Set(gobin.PasswordHeader, password)
+	}
 	return defaultClient.Do(request)
 }
 
-func Get(path string) (*http.Response, error) {
-	return Do(http.MethodGet, path, "", nil)
+func Get(path string, token string, password string) (*http.Response, error) {
+	return Do(http.MethodGet, path, token, password, nil)
 }
 
-func Post(path string, body io.Reader) (*http.Response, error) {
-	return Do(http.MethodPost, path, "", body)
+// Post creates a document at path, contentEncoding is the compression of the body or empty if it's uncompressed.
+func Post(path string, password string, contentEncoding string, body io.Reader) (*http.Response, error) {
+	header := http.Header{}
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+	return DoWithHeader(http.MethodPost, path, "", password, header, body)
 }
 
-func Patch(path string, token string, body io.Reader) (*http.Response, error) {
-	return Do(http.MethodPatch, path, token, body)
+// Patch updates the document at path, if ifMatch isn't empty only if it's the ETag of the current version.
+// contentEncoding is the compression of the body or empty if it's uncompressed.
+func Patch(path string, token string, password string, ifMatch string, contentEncoding string, body io.Reader) (*http.Response, error) {
+	header := http.Header{}
+	if ifMatch != "" {
+		header.Set(gobin.IfMatchHeader, ifMatch)
+	}
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+	return DoWithHeader(http.MethodPatch, path, token, password, header, body)
 }
 
 func Delete(path string, token string) (*http.Response, error) {
-	return Do(http.MethodDelete, path, token, nil)
+	return Do(http.MethodDelete, path, token, "", nil)
 }
 
 func ProcessBody(cmd *cobra.Command, method string, rs *http.Response, body any) bool {
@@ -4,11 +4,18 @@ import (
 	"context"
 	"embed"
 	"flag"
+	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/alecthomas/chroma/v2/formatters"
@@ -36,21 +43,31 @@ var (
 	//go:embed assets
 	Assets embed.FS
 
-	//go:embed sql/schema.sql
-	Schema string
+	//go:embed sql/migrations
+	Migrations embed.FS
 )
 
 func main() {
 	log.Printf("Starting Gobin with version: %s (commit: %s, build time: %s)...", version, commit, buildTime)
 	cfgPath := flag.String("config", "", "path to gobin.json")
+	compressVersions := flag.Bool("compress-versions", false, "store the versions of existing documents as deltas and exit")
+	recompressContents := flag.Bool("recompress-contents", false, "compress the contents of existing documents with the configured compression and exit")
+	reencryptContents := flag.Bool("reencrypt-contents", false, "encrypt the contents of existing documents with the configured encryption key and exit")
+	indexDocuments := flag.Bool("index-documents", false, "add existing documents which are missing from the search index to it and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [migrate up|down [steps]|status]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	viper.SetDefault("listen_addr", ":80")
+	viper.SetDefault("shutdown_timeout", "30s")
 	viper.SetDefault("dev_mode", false)
 	viper.SetDefault("database_type", "sqlite")
 	viper.SetDefault("database_debug", false)
 	viper.SetDefault("database_expire_after", "0")
 	viper.SetDefault("database_cleanup_interval", "1m")
+	viper.SetDefault("database_skip_migrations", false)
 	viper.SetDefault("database_path", "gobin.db")
 	viper.SetDefault("database_host", "localhost")
 	viper.SetDefault("database_port", 5432)
@@ -58,9 +75,27 @@ func main() {
 	viper.SetDefault("database_password", "password")
 	viper.SetDefault("database_database", "gobin")
 	viper.SetDefault("database_ssl_mode", "disable")
+	viper.SetDefault("database_compression", "")
+	viper.SetDefault("database_encryption_key_id", "")
+	viper.SetDefault("database_encryption_key_file", "")
+	viper.SetDefault("database_encryption_reencrypt_interval", "1h")
+	viper.SetDefault("database_content_storage_type", "")
+	viper.SetDefault("database_content_storage_path", "content")
+	viper.SetDefault("database_content_storage_region", "us-east-1")
+	viper.SetDefault("database_content_storage_use_ssl", true)
+	viper.SetDefault("database_content_storage_path_style", false)
 	viper.SetDefault("max_document_size", 0)
+	viper.SetDefault("max_body_size", 10<<20)
+	viper.SetDefault("raw_content_types", false)
 	viper.SetDefault("rate_limit_requests", 10)
 	viper.SetDefault("rate_limit_duration", "1m")
+	viper.SetDefault("metrics_enabled", false)
+	viper.SetDefault("metrics_listen_addr", "")
+	viper.SetDefault("render_workers", 0)
+	viper.SetDefault("render_timeout", "5s")
+	viper.SetDefault("render_max_size", 1<<20)
+	viper.SetDefault("render_cache_max_size", 64<<20)
+	viper.SetDefault("render_cache_ttl", "24h")
 
 	if *cfgPath != "" {
 		viper.SetConfigFile(*cfgPath)
@@ -85,13 +120,40 @@ func main() {
 	}
 	log.Println("Config:", cfg)
 
+	migrations, err := fs.Sub(Migrations, "sql/migrations")
+	if err != nil {
+		log.Fatalln("Error while loading migrations:", err)
+	}
+	if flag.Arg(0) == "migrate" {
+		runMigrate(cfg.Database, migrations, flag.Args()[1:])
+		return
+	}
+
+	if *compressVersions {
+		runDBCommand(cfg.Database, migrations, "compress document versions", "Compressed %d document versions", (*gobin.DB).CompressVersions)
+		return
+	}
+	if *recompressContents {
+		runDBCommand(cfg.Database, migrations, "recompress document contents", "Recompressed %d document contents", (*gobin.DB).RecompressContents)
+		return
+	}
+	if *reencryptContents {
+		runDBCommand(cfg.Database, migrations, "re-encrypt document contents", "Re-encrypted %d document contents", (*gobin.DB).ReencryptContents)
+		return
+	}
+	if *indexDocuments {
+		runDBCommand(cfg.Database, migrations, "index documents", "Indexed %d documents", (*gobin.DB).IndexDocuments)
+		return
+	}
+
+	metrics := gobin.NewMetrics(cfg.Metrics)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	db, err := gobin.NewDB(ctx, cfg.Database, Schema)
+	db, err := gobin.NewDB(ctx, cfg.Database, migrations, metrics)
 	if err != nil {
 		log.Fatalln("Error while connecting to database:", err)
 	}
-	defer db.Close()
 
 	key := jose.SigningKey{
 		Algorithm: jose.HS512,
@@ -144,7 +206,108 @@ func main() {
 		html.TabWidth(4),
 	))
 
-	s := gobin.NewServer(gobin.FormatBuildVersion(version, commit, buildTime), cfg, db, signer, assets, tmplFunc)
+	renderCache, err := gobin.NewRenderCache(cfg.RenderCache, metrics)
+	if err != nil {
+		log.Fatalln("Error while creating render cache:", err)
+	}
+
+	s := gobin.NewServer(gobin.FormatBuildVersion(version, commit, buildTime), cfg, db, signer, assets, tmplFunc, metrics, renderCache)
 	log.Println("Gobin listening on:", cfg.ListenAddr)
-	s.Start()
+	if metrics != nil && cfg.Metrics.ListenAddr != "" {
+		log.Println("Gobin metrics listening on:", cfg.Metrics.ListenAddr)
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		// a second signal kills gobin without waiting for the shutdown
+		<-signalCtx.Done()
+		stop()
+	}()
+	if err = s.Start(signalCtx); err != nil {
+		log.Fatalln("Error while serving:", err)
+	}
+	log.Println("Gobin stopped")
+}
+
+// runDBCommand opens the postgres & sqlite database, runs a maintenance command on it and closes the database afterwards.
+func runDBCommand(cfg gobin.DatabaseConfig, migrations fs.FS, name string, resultFormat string, command func(*gobin.DB, context.Context) (int64, error)) {
+	if cfg.Type != "postgres" && cfg.Type != "sqlite" {
+		log.Fatalf("Failed to %s: only supported by postgres and sqlite", name)
+	}
+	// the cleanup & re-encryption aren't started, they would compete with the command for the database
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	db, err := gobin.OpenSQLDB(ctx, cfg, migrations, nil)
+	cancel()
+	if err != nil {
+		log.Fatalln("Error while connecting to database:", err)
+	}
+	log.Printf("Starting to %s...", name)
+	rows, err := command(db, context.Background())
+	if closeErr := db.Close(); closeErr != nil {
+		log.Println("Error while closing database:", closeErr)
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s: %s", name, err)
+	}
+	log.Printf(resultFormat, rows)
+}
+
+// runMigrate applies, reverts or lists the migrations of the postgres & sqlite databases.
+func runMigrate(cfg gobin.DatabaseConfig, migrations fs.FS, args []string) {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfg.Type != "postgres" && cfg.Type != "sqlite" {
+		log.Fatalln("Migrations are only supported by postgres and sqlite")
+	}
+
+	ctx := context.Background()
+	migrator, err := gobin.NewMigrator(ctx, cfg, migrations)
+	if err != nil {
+		log.Fatalln("Error while connecting to database:", err)
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalln("Error while applying migrations:", err)
+		}
+		log.Printf("Applied %d migrations", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				log.Fatalln("Invalid number of steps:", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Fatalln("Error while reverting migrations:", err)
+		}
+		log.Printf("Reverted %d migrations", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalln("Error while getting migration status:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = time.Unix(*status.AppliedAt, 0).Format(time.RFC3339)
+			}
+			if status.Unknown {
+				appliedAt += " (unknown to this gobin version)"
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		_ = w.Flush()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 }
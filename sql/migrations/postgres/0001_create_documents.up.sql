@@ -1,4 +1,4 @@
-CREATE TABLE IF NOT EXISTS documents
+CREATE TABLE documents
 (
     id       VARCHAR NOT NULL,
     version  BIGINT  NOT NULL,
@@ -0,0 +1,15 @@
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM document_contents WHERE content_key IS NOT NULL) THEN
+            RAISE EXCEPTION 'document contents are kept in a content storage and can not be moved back into the documents table';
+        END IF;
+    END
+$$;
+
+ALTER TABLE documents ADD COLUMN content TEXT;
+UPDATE documents SET content = c.content FROM document_contents c WHERE c.hash = documents.content_hash;
+ALTER TABLE documents ALTER COLUMN content SET NOT NULL;
+ALTER TABLE documents DROP COLUMN content_hash;
+
+DROP TABLE document_contents;
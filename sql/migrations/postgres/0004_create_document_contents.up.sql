@@ -0,0 +1,18 @@
+CREATE TABLE document_contents
+(
+    hash        VARCHAR NOT NULL,
+    content     TEXT    NOT NULL,
+    content_key VARCHAR,
+    PRIMARY KEY (hash)
+);
+
+INSERT INTO document_contents (hash, content)
+SELECT DISTINCT encode(sha256(convert_to(content, 'UTF8')), 'hex'), content FROM documents
+ON CONFLICT (hash) DO NOTHING;
+
+ALTER TABLE documents ADD COLUMN content_hash VARCHAR REFERENCES document_contents (hash);
+UPDATE documents SET content_hash = encode(sha256(convert_to(content, 'UTF8')), 'hex');
+ALTER TABLE documents ALTER COLUMN content_hash SET NOT NULL;
+ALTER TABLE documents DROP COLUMN content;
+
+CREATE INDEX documents_content_hash_idx ON documents (content_hash);
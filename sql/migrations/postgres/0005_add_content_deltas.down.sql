@@ -0,0 +1,10 @@
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM document_contents WHERE base_hash IS NOT NULL) THEN
+            RAISE EXCEPTION 'document versions are stored as deltas and can not be reconstructed by a migration';
+        END IF;
+    END
+$$;
+
+ALTER TABLE document_contents DROP COLUMN base_hash;
@@ -0,0 +1,3 @@
+ALTER TABLE document_contents ADD COLUMN base_hash VARCHAR REFERENCES document_contents (hash);
+
+CREATE INDEX document_contents_base_hash_idx ON document_contents (base_hash);
@@ -0,0 +1,11 @@
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM document_contents WHERE compression IS NOT NULL) THEN
+            RAISE EXCEPTION 'document contents are compressed, decompress them with -recompress-contents and no compression first';
+        END IF;
+    END
+$$;
+
+ALTER TABLE document_contents DROP COLUMN compression;
+ALTER TABLE document_contents DROP COLUMN compressed_content;
@@ -0,0 +1,2 @@
+ALTER TABLE document_contents ADD COLUMN compressed_content BYTEA;
+ALTER TABLE document_contents ADD COLUMN compression VARCHAR;
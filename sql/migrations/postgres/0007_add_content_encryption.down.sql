@@ -0,0 +1,11 @@
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM document_contents WHERE encryption_key_id IS NOT NULL) THEN
+            RAISE EXCEPTION 'document contents are encrypted, decrypt them with -reencrypt-contents and no key_id first';
+        END IF;
+    END
+$$;
+
+ALTER TABLE document_contents DROP COLUMN encryption_key_id;
+ALTER TABLE document_contents DROP COLUMN encrypted_content;
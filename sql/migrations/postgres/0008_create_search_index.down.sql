@@ -0,0 +1 @@
+DROP INDEX document_contents_search_idx;
@@ -0,0 +1,2 @@
+-- only contents stored in full are indexed, deltas are never the latest version of a document
+CREATE INDEX document_contents_search_idx ON document_contents USING GIN (to_tsvector('simple', content)) WHERE base_hash IS NULL;
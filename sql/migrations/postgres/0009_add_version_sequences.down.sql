@@ -0,0 +1,14 @@
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM documents GROUP BY id, COALESCE(legacy_version, created_at) HAVING COUNT(*) > 1) THEN
+            RAISE EXCEPTION 'documents have multiple versions created in the same second, which can not be converted back to unix second versions';
+        END IF;
+    END
+$$;
+
+DROP TABLE document_sequences;
+
+UPDATE documents SET version = COALESCE(legacy_version, created_at);
+ALTER TABLE documents DROP COLUMN legacy_version;
+ALTER TABLE documents DROP COLUMN created_at;
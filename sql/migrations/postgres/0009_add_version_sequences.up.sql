@@ -0,0 +1,22 @@
+-- versions were the unix second they were created at, they become a sequence number per document
+-- the old versions are kept as legacy_version, so links to them keep working
+ALTER TABLE documents ADD COLUMN created_at BIGINT;
+ALTER TABLE documents ADD COLUMN legacy_version BIGINT;
+UPDATE documents SET created_at = version, legacy_version = version;
+ALTER TABLE documents ALTER COLUMN created_at SET NOT NULL;
+
+UPDATE documents
+SET version = numbered.version
+FROM (SELECT id, version AS legacy_version, ROW_NUMBER() OVER (PARTITION BY id ORDER BY version) AS version FROM documents) numbered
+WHERE documents.id = numbered.id AND documents.version = numbered.legacy_version;
+
+-- document_sequences holds the last version of every document, so versions are never reused even if the latest version is deleted
+CREATE TABLE document_sequences
+(
+    id           VARCHAR NOT NULL,
+    last_version BIGINT  NOT NULL,
+    PRIMARY KEY (id)
+);
+
+INSERT INTO document_sequences (id, last_version)
+SELECT id, MAX(version) FROM documents GROUP BY id;
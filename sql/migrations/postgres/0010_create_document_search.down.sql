@@ -0,0 +1,3 @@
+DROP TABLE document_search;
+
+CREATE INDEX document_contents_search_idx ON document_contents USING GIN (to_tsvector('simple', content)) WHERE base_hash IS NULL;
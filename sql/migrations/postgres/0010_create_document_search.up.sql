@@ -0,0 +1,25 @@
+-- the search index only covers the latest version of every document, which is indexed from its plain text when it's written
+-- contents are no longer indexed in place, as compressed contents and contents in the content storage have no plain text in document_contents
+DROP INDEX document_contents_search_idx;
+
+CREATE TABLE document_search
+(
+    id            VARCHAR  NOT NULL,
+    content_hash  VARCHAR  NOT NULL,
+    search_vector TSVECTOR NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX document_search_vector_idx ON document_search USING GIN (search_vector);
+
+-- contents stored as plain text are indexed right away, run gobin with -index-documents to index compressed contents and contents in the content storage
+INSERT INTO document_search (id, content_hash, search_vector)
+SELECT d.id, d.content_hash, to_tsvector('simple', c.content)
+FROM documents d
+         JOIN document_contents c ON c.hash = d.content_hash
+WHERE d.version = (SELECT MAX(version) FROM documents WHERE id = d.id)
+  AND NOT d.encrypted
+  AND c.base_hash IS NULL
+  AND c.compression IS NULL
+  AND c.encryption_key_id IS NULL
+  AND c.content_key IS NULL;
@@ -0,0 +1,8 @@
+CREATE TABLE documents
+(
+    id       VARCHAR NOT NULL,
+    version  BIGINT  NOT NULL,
+    content  TEXT    NOT NULL,
+    language VARCHAR NOT NULL,
+    PRIMARY KEY (id, version)
+);
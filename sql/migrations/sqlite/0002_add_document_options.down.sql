@@ -0,0 +1,6 @@
+ALTER TABLE documents DROP COLUMN public;
+ALTER TABLE documents DROP COLUMN private;
+ALTER TABLE documents DROP COLUMN encrypted;
+ALTER TABLE documents DROP COLUMN password_hash;
+ALTER TABLE documents DROP COLUMN views_left;
+ALTER TABLE documents DROP COLUMN expires_at;
@@ -0,0 +1,6 @@
+ALTER TABLE documents ADD COLUMN expires_at BIGINT;
+ALTER TABLE documents ADD COLUMN views_left BIGINT;
+ALTER TABLE documents ADD COLUMN password_hash VARCHAR;
+ALTER TABLE documents ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE documents ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE documents ADD COLUMN public BOOLEAN NOT NULL DEFAULT FALSE;
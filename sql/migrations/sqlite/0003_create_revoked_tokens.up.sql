@@ -0,0 +1,8 @@
+CREATE TABLE revoked_tokens
+(
+    document_id VARCHAR NOT NULL,
+    token_id    VARCHAR NOT NULL,
+    revoked_at  BIGINT  NOT NULL,
+    expires_at  BIGINT,
+    PRIMARY KEY (document_id, token_id)
+);
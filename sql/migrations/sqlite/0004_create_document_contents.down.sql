@@ -0,0 +1,30 @@
+-- sqlite can't raise errors outside of triggers, the check constraint fails instead
+CREATE TEMP TABLE migration_check
+(
+    contents_in_content_storage INTEGER NOT NULL CHECK (contents_in_content_storage = 0)
+);
+INSERT INTO migration_check SELECT COUNT(*) FROM document_contents WHERE content_key IS NOT NULL;
+DROP TABLE migration_check;
+
+CREATE TABLE documents_old
+(
+    id            VARCHAR NOT NULL,
+    version       BIGINT  NOT NULL,
+    content       TEXT    NOT NULL,
+    language      VARCHAR NOT NULL,
+    expires_at    BIGINT,
+    views_left    BIGINT,
+    password_hash VARCHAR,
+    encrypted     BOOLEAN NOT NULL DEFAULT FALSE,
+    private       BOOLEAN NOT NULL DEFAULT FALSE,
+    public        BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (id, version)
+);
+
+INSERT INTO documents_old (id, version, content, language, expires_at, views_left, password_hash, encrypted, private, public)
+SELECT d.id, d.version, c.content, d.language, d.expires_at, d.views_left, d.password_hash, d.encrypted, d.private, d.public FROM documents d JOIN document_contents c ON c.hash = d.content_hash;
+
+DROP TABLE documents;
+ALTER TABLE documents_old RENAME TO documents;
+
+DROP TABLE document_contents;
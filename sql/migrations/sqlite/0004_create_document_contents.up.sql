@@ -0,0 +1,35 @@
+CREATE TABLE document_contents
+(
+    hash        VARCHAR NOT NULL,
+    content     TEXT    NOT NULL,
+    content_key VARCHAR,
+    PRIMARY KEY (hash)
+);
+
+INSERT INTO document_contents (hash, content)
+SELECT DISTINCT gobin_sha256(content), content FROM documents WHERE TRUE
+ON CONFLICT (hash) DO NOTHING;
+
+-- sqlite can't add a NOT NULL foreign key column to an existing table, so the table is recreated
+CREATE TABLE documents_new
+(
+    id            VARCHAR NOT NULL,
+    version       BIGINT  NOT NULL,
+    content_hash  VARCHAR NOT NULL REFERENCES document_contents (hash),
+    language      VARCHAR NOT NULL,
+    expires_at    BIGINT,
+    views_left    BIGINT,
+    password_hash VARCHAR,
+    encrypted     BOOLEAN NOT NULL DEFAULT FALSE,
+    private       BOOLEAN NOT NULL DEFAULT FALSE,
+    public        BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (id, version)
+);
+
+INSERT INTO documents_new (id, version, content_hash, language, expires_at, views_left, password_hash, encrypted, private, public)
+SELECT id, version, gobin_sha256(content), language, expires_at, views_left, password_hash, encrypted, private, public FROM documents;
+
+DROP TABLE documents;
+ALTER TABLE documents_new RENAME TO documents;
+
+CREATE INDEX documents_content_hash_idx ON documents (content_hash);
@@ -0,0 +1,24 @@
+-- deltas can't be reconstructed by a migration, sqlite can't raise errors outside of triggers, the check constraint fails instead
+CREATE TEMP TABLE migration_check
+(
+    contents_stored_as_delta INTEGER NOT NULL CHECK (contents_stored_as_delta = 0)
+);
+INSERT INTO migration_check SELECT COUNT(*) FROM document_contents WHERE base_hash IS NOT NULL;
+DROP TABLE migration_check;
+
+-- sqlite can't drop foreign key columns, so the table is recreated
+DROP INDEX document_contents_base_hash_idx;
+
+CREATE TABLE document_contents_old
+(
+    hash        VARCHAR NOT NULL,
+    content     TEXT    NOT NULL,
+    content_key VARCHAR,
+    PRIMARY KEY (hash)
+);
+
+INSERT INTO document_contents_old (hash, content, content_key)
+SELECT hash, content, content_key FROM document_contents;
+
+DROP TABLE document_contents;
+ALTER TABLE document_contents_old RENAME TO document_contents;
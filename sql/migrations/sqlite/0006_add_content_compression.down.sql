@@ -0,0 +1,10 @@
+-- decompress contents with -recompress-contents and no compression first, sqlite can't raise errors outside of triggers, the check constraint fails instead
+CREATE TEMP TABLE migration_check
+(
+    compressed_contents INTEGER NOT NULL CHECK (compressed_contents = 0)
+);
+INSERT INTO migration_check SELECT COUNT(*) FROM document_contents WHERE compression IS NOT NULL;
+DROP TABLE migration_check;
+
+ALTER TABLE document_contents DROP COLUMN compression;
+ALTER TABLE document_contents DROP COLUMN compressed_content;
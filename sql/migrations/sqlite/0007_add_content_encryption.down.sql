@@ -0,0 +1,10 @@
+-- decrypt contents with -reencrypt-contents and no key_id first, sqlite can't raise errors outside of triggers, the check constraint fails instead
+CREATE TEMP TABLE migration_check
+(
+    encrypted_contents INTEGER NOT NULL CHECK (encrypted_contents = 0)
+);
+INSERT INTO migration_check SELECT COUNT(*) FROM document_contents WHERE encryption_key_id IS NOT NULL;
+DROP TABLE migration_check;
+
+ALTER TABLE document_contents DROP COLUMN encryption_key_id;
+ALTER TABLE document_contents DROP COLUMN encrypted_content;
@@ -0,0 +1,2 @@
+ALTER TABLE document_contents ADD COLUMN encrypted_content BYTEA;
+ALTER TABLE document_contents ADD COLUMN encryption_key_id VARCHAR;
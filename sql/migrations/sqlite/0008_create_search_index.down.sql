@@ -0,0 +1,4 @@
+DROP TRIGGER document_contents_fts_delete;
+DROP TRIGGER document_contents_fts_update;
+DROP TRIGGER document_contents_fts_insert;
+DROP TABLE document_contents_fts;
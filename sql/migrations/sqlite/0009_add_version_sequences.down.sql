@@ -0,0 +1,13 @@
+-- versions created in the same second can't be converted back to unix second versions, sqlite can't raise errors outside of triggers, the check constraint fails instead
+CREATE TEMP TABLE migration_check
+(
+    versions_created_in_same_second INTEGER NOT NULL CHECK (versions_created_in_same_second = 0)
+);
+INSERT INTO migration_check SELECT COUNT(*) FROM (SELECT 1 FROM documents GROUP BY id, COALESCE(legacy_version, created_at) HAVING COUNT(*) > 1);
+DROP TABLE migration_check;
+
+DROP TABLE document_sequences;
+
+UPDATE documents SET version = COALESCE(legacy_version, created_at);
+ALTER TABLE documents DROP COLUMN legacy_version;
+ALTER TABLE documents DROP COLUMN created_at;
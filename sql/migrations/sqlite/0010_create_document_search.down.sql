@@ -0,0 +1,19 @@
+DROP TABLE document_search_fts;
+DROP TABLE document_search;
+
+CREATE VIRTUAL TABLE document_contents_fts USING fts5(hash UNINDEXED, content, tokenize = 'unicode61');
+
+CREATE TRIGGER document_contents_fts_insert AFTER INSERT ON document_contents BEGIN
+    INSERT INTO document_contents_fts (hash, content) VALUES (new.hash, new.content);
+END;
+
+CREATE TRIGGER document_contents_fts_update AFTER UPDATE OF content ON document_contents BEGIN
+    DELETE FROM document_contents_fts WHERE hash = old.hash;
+    INSERT INTO document_contents_fts (hash, content) SELECT new.hash, new.content WHERE new.base_hash IS NULL;
+END;
+
+CREATE TRIGGER document_contents_fts_delete AFTER DELETE ON document_contents BEGIN
+    DELETE FROM document_contents_fts WHERE hash = old.hash;
+END;
+
+INSERT INTO document_contents_fts (hash, content) SELECT hash, content FROM document_contents WHERE base_hash IS NULL;
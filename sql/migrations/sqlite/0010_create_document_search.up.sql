@@ -0,0 +1,30 @@
+-- the search index only covers the latest version of every document, which is indexed from its plain text when it's written
+-- document_search_fts is contentless, so it doesn't keep a copy of the contents, and document_search maps its rows to documents
+DROP TRIGGER document_contents_fts_delete;
+DROP TRIGGER document_contents_fts_update;
+DROP TRIGGER document_contents_fts_insert;
+DROP TABLE document_contents_fts;
+
+CREATE TABLE document_search
+(
+    search_id    INTEGER PRIMARY KEY,
+    id           VARCHAR NOT NULL UNIQUE,
+    content_hash VARCHAR NOT NULL
+);
+
+CREATE VIRTUAL TABLE document_search_fts USING fts5(content, content = '', tokenize = 'unicode61');
+
+-- contents stored as plain text are indexed right away, run gobin with -index-documents to index compressed contents and contents in the content storage
+INSERT INTO document_search (id, content_hash)
+SELECT d.id, d.content_hash
+FROM documents d
+         JOIN document_contents c ON c.hash = d.content_hash
+WHERE d.version = (SELECT MAX(version) FROM documents WHERE id = d.id)
+  AND NOT d.encrypted
+  AND c.base_hash IS NULL
+  AND c.compression IS NULL
+  AND c.encryption_key_id IS NULL
+  AND c.content_key IS NULL;
+
+INSERT INTO document_search_fts (rowid, content)
+SELECT s.search_id, c.content FROM document_search s JOIN document_contents c ON c.hash = s.content_hash;